@@ -0,0 +1,93 @@
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"StealthIMGroupUser/config"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// ServerCredentials 按配置创建服务端凭据，配置客户端 CA 时要求双向认证
+func ServerCredentials(cfg config.ServerTLSConfig) (credentials.TransportCredentials, error) {
+	keyPair, err := newKeyPairReloader(cfg.Cert, cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	var clientCA *reloader[*x509.CertPool]
+	if cfg.ClientCA != "" {
+		clientCA, err = newPoolReloader(cfg.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// 每次握手重新生成配置，使更新后的证书对新连接生效
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			connCfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*keyPair.get()},
+				NextProtos:   []string{"h2"},
+			}
+			if clientCA != nil {
+				connCfg.ClientCAs = clientCA.get()
+				connCfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return connCfg, nil
+		},
+	}
+	return credentials.NewTLS(tlsCfg), nil
+}
+
+// ClientCredentials 按上游配置创建连接凭据，未启用 TLS 时使用明文
+func ClientCredentials(cfg config.ClientTLSConfig) (credentials.TransportCredentials, error) {
+	if !cfg.Enable {
+		return insecure.NewCredentials(), nil
+	}
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+	if cfg.Cert != "" || cfg.Key != "" {
+		keyPair, err := newKeyPairReloader(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return keyPair.get(), nil
+		}
+	}
+	if cfg.CA != "" {
+		roots, err := newPoolReloader(cfg.CA)
+		if err != nil {
+			return nil, err
+		}
+		// 标准校验无法更换根证书，改为握手时使用最新的 CA 自行校验
+		tlsCfg.InsecureSkipVerify = true
+		tlsCfg.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPeer(state, roots.get())
+		}
+	}
+	return credentials.NewTLS(tlsCfg), nil
+}
+
+// verifyPeer 使用指定 CA 校验服务端证书链与主机名
+func verifyPeer(state tls.ConnectionState, roots *x509.CertPool) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no peer certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       state.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, intermediate := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(intermediate)
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
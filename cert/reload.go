@@ -0,0 +1,109 @@
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// checkInterval 两次检查证书文件变化的最小间隔
+const checkInterval = time.Second
+
+// loaded 已加载的内容及加载时的文件状态
+type loaded[T any] struct {
+	stamp string
+	value T
+}
+
+// reloader 在证书文件变化时重新加载，加载失败时继续使用旧内容
+type reloader[T any] struct {
+	paths     []string
+	load      func() (T, error)
+	current   atomic.Pointer[loaded[T]]
+	lastCheck atomic.Int64
+}
+
+func newReloader[T any](load func() (T, error), paths ...string) (*reloader[T], error) {
+	r := &reloader[T]{paths: paths, load: load}
+	stamp, err := r.stamp()
+	if err != nil {
+		return nil, err
+	}
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	r.current.Store(&loaded[T]{stamp: stamp, value: value})
+	r.lastCheck.Store(time.Now().UnixNano())
+	return r, nil
+}
+
+// stamp 以修改时间与大小标识文件状态
+func (r *reloader[T]) stamp() (string, error) {
+	var builder strings.Builder
+	for _, path := range r.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&builder, "%d:%d;", info.ModTime().UnixNano(), info.Size())
+	}
+	return builder.String(), nil
+}
+
+// get 返回当前内容，距上次检查超过间隔时检查文件是否变化
+func (r *reloader[T]) get() T {
+	cur := r.current.Load()
+	now := time.Now().UnixNano()
+	last := r.lastCheck.Load()
+	if now-last < int64(checkInterval) || !r.lastCheck.CompareAndSwap(last, now) {
+		return cur.value
+	}
+	stamp, err := r.stamp()
+	if err != nil {
+		log.Printf("[CERT]Stat %v error: %v\n", r.paths, err)
+		return cur.value
+	}
+	if stamp == cur.stamp {
+		return cur.value
+	}
+	value, err := r.load()
+	if err != nil {
+		log.Printf("[CERT]Reload %v error: %v\n", r.paths, err)
+		return cur.value
+	}
+	r.current.Store(&loaded[T]{stamp: stamp, value: value})
+	log.Printf("[CERT]Reloaded %v\n", r.paths)
+	return value
+}
+
+// newKeyPairReloader 加载证书与私钥
+func newKeyPairReloader(certFile string, keyFile string) (*reloader[*tls.Certificate], error) {
+	return newReloader(func() (*tls.Certificate, error) {
+		keyPair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &keyPair, nil
+	}, certFile, keyFile)
+}
+
+// newPoolReloader 加载 PEM 格式的 CA 证书
+func newPoolReloader(caFile string) (*reloader[*x509.CertPool], error) {
+	return newReloader(func() (*x509.CertPool, error) {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate in %s", caFile)
+		}
+		return pool, nil
+	}, caFile)
+}
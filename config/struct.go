@@ -7,39 +7,95 @@ type Config struct {
 	Session   SessionConfig   `toml:"session"`
 	User      UserConfig      `toml:"user"`
 	Security  SecurityConfig  `toml:"security"`
+	Group     GroupConfig     `toml:"group"`
+	Outbox    OutboxConfig    `toml:"outbox"`
+	Webhook   WebhookConfig   `toml:"webhook"`
 }
 
 // GRPCProxyConfig grpc Server配置
 type GRPCProxyConfig struct {
-	Host string `toml:"host"`
-	Port int    `toml:"port"`
-	Log  bool   `toml:"log"`
+	Host string          `toml:"host"`
+	Port int             `toml:"port"`
+	Log  bool            `toml:"log"`
+	TLS  ServerTLSConfig `toml:"tls"`
 }
 
 // DBGatewayConfig grpc DBGateway 配置
 type DBGatewayConfig struct {
-	Host    string `toml:"host"`
-	Port    int    `toml:"port"`
-	ConnNum int    `toml:"conn_num"`
-	Timeout int    `toml:"sql_timeout"`
+	Host    string          `toml:"host"`
+	Port    int             `toml:"port"`
+	ConnNum int             `toml:"conn_num"`
+	Timeout int             `toml:"sql_timeout"`
+	TLS     ClientTLSConfig `toml:"tls"`
 }
 
 // UserConfig grpc User 配置
 type UserConfig struct {
-	Host    string `toml:"host"`
-	Port    int    `toml:"port"`
-	ConnNum int    `toml:"conn_num"`
-	Timeout int    `toml:"sql_timeout"`
+	Host    string          `toml:"host"`
+	Port    int             `toml:"port"`
+	ConnNum int             `toml:"conn_num"`
+	Timeout int             `toml:"sql_timeout"`
+	TLS     ClientTLSConfig `toml:"tls"`
 }
 
 // SessionConfig grpc Session 配置
 type SessionConfig struct {
-	Host    string `toml:"host"`
-	Port    int    `toml:"port"`
-	ConnNum int    `toml:"conn_num"`
+	Host    string          `toml:"host"`
+	Port    int             `toml:"port"`
+	ConnNum int             `toml:"conn_num"`
+	Timeout int             `toml:"timeout"`
+	Auth    bool            `toml:"auth"`
+	TLS     ClientTLSConfig `toml:"tls"`
+}
+
+// ServerTLSConfig 服务端 TLS 配置，未配置证书时使用明文
+// Services 为可信服务客户端证书的名称，需同时配置客户端 CA
+type ServerTLSConfig struct {
+	Cert     string   `toml:"cert"`
+	Key      string   `toml:"key"`
+	ClientCA string   `toml:"client_ca"`
+	Services []string `toml:"services"`
+}
+
+// ClientTLSConfig 上游连接 TLS 配置
+type ClientTLSConfig struct {
+	Enable     bool   `toml:"enable"`
+	CA         string `toml:"ca"`
+	Cert       string `toml:"cert"`
+	Key        string `toml:"key"`
+	ServerName string `toml:"server_name"`
 }
 
 // SecurityConfig 安全配置
 type SecurityConfig struct {
 	PasswordSalt string `toml:"password_salt"`
 }
+
+// GroupConfig 群组策略配置
+type GroupConfig struct {
+	Succession   string `toml:"succession"`
+	MaxMembers   int    `toml:"max_members"`
+	EventHistory int    `toml:"event_history"`
+}
+
+// OutboxConfig 成员变化事件发件箱配置
+type OutboxConfig struct {
+	Enable      bool            `toml:"enable"`
+	Interval    int             `toml:"interval"`
+	BatchSize   int             `toml:"batch_size"`
+	MaxAttempts int             `toml:"max_attempts"`
+	GRPCTarget  string          `toml:"grpc_target"`
+	WebhookURL  string          `toml:"webhook_url"`
+	LogFile     string          `toml:"log_file"`
+	TLS         ClientTLSConfig `toml:"tls"`
+}
+
+// WebhookConfig 群组事件回调配置，需同时启用发件箱
+type WebhookConfig struct {
+	Enable        bool     `toml:"enable"`
+	Interval      int      `toml:"interval"`
+	BatchSize     int      `toml:"batch_size"`
+	MaxAttempts   int      `toml:"max_attempts"`
+	Timeout       int      `toml:"timeout"`
+	AllowNetworks []string `toml:"allow_networks"`
+}
@@ -17,4 +17,22 @@ const (
 	GroupUserQueryError
 	// GroupUserInsertError 插入操作错误
 	GroupUserInsertError
+	// GroupUserInvalidArgument 请求参数错误
+	GroupUserInvalidArgument
+	// GroupUserApprovalRequired 群组需要审核才能加入
+	GroupUserApprovalRequired
+	// GroupUserInviteOnly 群组仅允许邀请加入
+	GroupUserInviteOnly
+	// GroupUserRequestExists 已存在待处理的申请
+	GroupUserRequestExists
+	// GroupUserGroupFull 群组人数已达上限
+	GroupUserGroupFull
+	// GroupUserInviteLinkInvalid 邀请链接无效、过期或已用尽
+	GroupUserInviteLinkInvalid
+	// GroupUserBanned 用户已被群组封禁
+	GroupUserBanned
+	// GroupUserEventsExpired 续传的事件序号已失效
+	GroupUserEventsExpired
+	// GroupUserUnauthenticated 会话缺失或无效
+	GroupUserUnauthenticated
 )
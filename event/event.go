@@ -0,0 +1,88 @@
+package event
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/config"
+)
+
+// defaultHistorySize 未配置时保留的最近事件数
+const defaultHistorySize = 1024
+
+// subscriberBuffer 单个订阅者的待发送事件数，写满时断开该订阅者
+const subscriberBuffer = 256
+
+var (
+	lock sync.Mutex
+	// lastSeq 以启动时间为起点，使重启前的序号在续传时失效
+	lastSeq     = time.Now().UnixMicro()
+	history     []*pb.GroupEvent
+	subscribers = map[int64]chan *pb.GroupEvent{}
+	nextSubID   int64
+)
+
+// Publish 分配序号并向本实例的全部订阅者广播事件，事件与序号不在多个实例间共享
+func Publish(ev *pb.GroupEvent) {
+	lock.Lock()
+	defer lock.Unlock()
+	lastSeq++
+	ev.Seq = lastSeq
+	ev.Timestamp = time.Now().Unix()
+
+	historySize := config.LatestConfig.Group.EventHistory
+	if historySize <= 0 {
+		historySize = defaultHistorySize
+	}
+	history = append(history, ev)
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+
+	for id, ch := range subscribers {
+		select {
+		case ch <- ev:
+		default:
+			// 消费过慢的订阅者直接断开，由客户端按序号续传
+			close(ch)
+			delete(subscribers, id)
+		}
+	}
+}
+
+// Subscribe 订阅 fromSeq 之后的事件并返回需要补发的历史事件，fromSeq 为 0 时只接收新事件
+// 所需事件已不在保留范围内时返回 false
+func Subscribe(fromSeq int64) (int64, <-chan *pb.GroupEvent, []*pb.GroupEvent, bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	var backlog []*pb.GroupEvent
+	if fromSeq != 0 {
+		if fromSeq > lastSeq {
+			return 0, nil, nil, false
+		}
+		if fromSeq < lastSeq {
+			if len(history) == 0 || history[0].Seq > fromSeq+1 {
+				return 0, nil, nil, false
+			}
+			index := sort.Search(len(history), func(i int) bool { return history[i].Seq > fromSeq })
+			backlog = slices.Clone(history[index:])
+		}
+	}
+	nextSubID++
+	ch := make(chan *pb.GroupEvent, subscriberBuffer)
+	subscribers[nextSubID] = ch
+	return nextSubID, ch, backlog, true
+}
+
+// Unsubscribe 取消订阅
+func Unsubscribe(id int64) {
+	lock.Lock()
+	defer lock.Unlock()
+	if ch, ok := subscribers[id]; ok {
+		close(ch)
+		delete(subscribers, id)
+	}
+}
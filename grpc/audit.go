@@ -0,0 +1,97 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+)
+
+const auditColumns = "`id`, `actor_uid`, `action`, `target`, `old_value`, `new_value`, `create_time`"
+
+// appendAuditLog 在变更提交后同步写入一条群组审计记录
+// 变更已提交，写入失败只记录日志，不影响调用结果
+func appendAuditLog(groupID int32, actorUID int32, action pb.AuditAction, target string, oldValue string, newValue string) {
+	insertReq := &pb_gtw.SqlRequest{
+		Sql:    "INSERT INTO `group_audit_log` (`groupid`, `actor_uid`, `action`, `target`, `old_value`, `new_value`) VALUES (?, ?, ?, ?, ?, ?)",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: actorUID}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: action.String()}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: target}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: oldValue}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: newValue}},
+		},
+	}
+	insertResp, err := gateway.ExecSQL(insertReq)
+	if err != nil {
+		log.Printf("[GRPC]Audit log error: %v\n", err)
+		return
+	}
+	if insertResp.Result.Code != errorcode.Success {
+		log.Printf("[GRPC]Audit log error: [%d]%s\n", insertResp.Result.Code, insertResp.Result.Msg)
+	}
+}
+
+// ListGroupAuditLog 按时间倒序分页获取群组审计记录，仅管理员以上可用
+func (s *server) ListGroupAuditLog(ctx context.Context, req *pb.ListGroupAuditLogRequest) (*pb.ListGroupAuditLogResponse, error) {
+	limit := normalizeLimit(req.Limit)
+	sql := "SELECT " + auditColumns + " FROM `group_audit_log` WHERE `groupid` = ?"
+	params := []*pb_gtw.InterFaceType{
+		{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+	}
+	if req.Cursor > 0 {
+		sql += " AND `id` < ?"
+		params = append(params, &pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Int64{Int64: req.Cursor}})
+	}
+	if req.ActionFilter != nil {
+		sql += " AND `action` = ?"
+		params = append(params, &pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Str{Str: req.GetActionFilter().String()}})
+	}
+	sql += " ORDER BY `id` DESC LIMIT ?"
+	params = append(params, &pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Int32{Int32: limit}})
+
+	sqlResp, err := gateway.ExecSQL(&pb_gtw.SqlRequest{Sql: sql, Db: pb_gtw.SqlDatabases_Groups, Params: params})
+	if err != nil {
+		return &pb.ListGroupAuditLogResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return &pb.ListGroupAuditLogResponse{
+			Result: &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg},
+		}, nil
+	}
+	var entries []*pb.AuditLogObject
+	nextCursor := int64(0)
+	for _, row := range sqlResp.Data {
+		if len(row.Result) < 7 {
+			continue
+		}
+		nextCursor = row.Result[0].GetInt64()
+		entries = append(entries, &pb.AuditLogObject{
+			Id:        nextCursor,
+			GroupId:   req.GroupId,
+			ActorUid:  row.Result[1].GetInt32(),
+			Action:    pb.AuditAction(pb.AuditAction_value[row.Result[2].GetStr()]),
+			Target:    row.Result[3].GetStr(),
+			OldValue:  row.Result[4].GetStr(),
+			NewValue:  row.Result[5].GetStr(),
+			CreatedAt: row.Result[6].GetInt64(),
+		})
+	}
+	if len(entries) < int(limit) {
+		nextCursor = 0
+	}
+	return &pb.ListGroupAuditLogResponse{
+		Result:     &pb.Result{Code: errorcode.Success},
+		Entries:    entries,
+		NextCursor: nextCursor,
+	}, nil
+}
@@ -0,0 +1,172 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/config"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/session"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// sessionMetadataKey 请求元数据中携带会话的键
+const sessionMetadataKey = "session"
+
+// skipAuth 无需校验会话的方法
+func skipAuth(fullMethod string) bool {
+	return strings.HasSuffix(fullMethod, "/Ping")
+}
+
+// serviceCaller 判断调用方是否持有可信服务的客户端证书，可信服务可代任意用户调用
+func serviceCaller(ctx context.Context) bool {
+	tlsCfg := config.LatestConfig.GRPCProxy.TLS
+	if tlsCfg.ClientCA == "" || len(tlsCfg.Services) == 0 {
+		return false
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return false
+	}
+	leaf := tlsInfo.State.VerifiedChains[0][0]
+	if slices.Contains(tlsCfg.Services, leaf.Subject.CommonName) {
+		return true
+	}
+	for _, name := range leaf.DNSNames {
+		if slices.Contains(tlsCfg.Services, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate 校验元数据中的会话并返回会话 uid
+func authenticate(ctx context.Context) (int32, *pb.Result) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(sessionMetadataKey)
+	if len(values) == 0 || values[0] == "" {
+		return 0, &pb.Result{Code: errorcode.GroupUserUnauthenticated, Msg: "Session required"}
+	}
+	uid, err := session.QueryUIDBySession(ctx, values[0])
+	if err != nil {
+		return 0, &pb.Result{Code: errorcode.GroupUserUnauthenticated, Msg: fmt.Sprintf("Session invalid: %v", err)}
+	}
+	return uid, nil
+}
+
+// callerField 查找请求中表示调用者的字段
+func callerField(msg proto.Message, name protoreflect.Name) protoreflect.FieldDescriptor {
+	if name == "" {
+		return nil
+	}
+	field := msg.ProtoReflect().Descriptor().Fields().ByName(name)
+	if field == nil || field.Kind() != protoreflect.Int32Kind || field.Cardinality() == protoreflect.Repeated {
+		return nil
+	}
+	return field
+}
+
+// bindUID 将请求中的调用者字段绑定为会话 uid，请求未填写时补全，与会话不符时拒绝
+func bindUID(req any, name protoreflect.Name, uid int32) *pb.Result {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil
+	}
+	field := callerField(msg, name)
+	if field == nil {
+		return nil
+	}
+	reflectMsg := msg.ProtoReflect()
+	reqUID := int32(reflectMsg.Get(field).Int())
+	if reqUID == 0 {
+		reflectMsg.Set(field, protoreflect.ValueOfInt32(uid))
+	} else if reqUID != uid {
+		return &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Uid mismatch"}
+	}
+	return nil
+}
+
+// checkSession 校验会话调用方：仅限服务的方法直接拒绝，其余方法绑定授权表中登记的调用者字段
+func checkSession(ctx context.Context, fullMethod string, req any) *pb.Result {
+	p := policies[fullMethod]
+	if p.level == accessService {
+		return &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Service only"}
+	}
+	uid, res := authenticate(ctx)
+	if res != nil {
+		return res
+	}
+	return bindUID(req, p.caller, uid)
+}
+
+// authRequired 判断调用是否需要校验会话
+func authRequired(ctx context.Context, fullMethod string) bool {
+	return config.LatestConfig.Session.Auth && !skipAuth(fullMethod) && !serviceCaller(ctx)
+}
+
+// authUnaryInterceptor 校验一元调用的会话，失败时与处理函数一样在响应的结果中返回
+func authUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !authRequired(ctx, info.FullMethod) {
+		return handler(ctx, req)
+	}
+	if res := checkSession(ctx, info.FullMethod, req); res != nil {
+		return resultResponse(info.FullMethod, res)
+	}
+	return handler(ctx, req)
+}
+
+// preReadStream 将拦截器预先读取并校验过的请求交给处理函数
+type preReadStream struct {
+	grpc.ServerStream
+	req proto.Message
+}
+
+func (s *preReadStream) RecvMsg(m any) error {
+	if s.req == nil {
+		return s.ServerStream.RecvMsg(m)
+	}
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", m)
+	}
+	proto.Reset(msg)
+	proto.Merge(msg, s.req)
+	s.req = nil
+	return nil
+}
+
+// authStreamInterceptor 校验服务端流式调用的会话
+// 先读取唯一的请求完成校验，失败时以携带结果的响应结束调用；客户端流式方法无法预先校验，会话调用方一律拒绝
+func authStreamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := stream.Context()
+	if !authRequired(ctx, info.FullMethod) {
+		return handler(srv, stream)
+	}
+	if info.IsClientStream {
+		return sendResult(stream, info.FullMethod, &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Service only"})
+	}
+	req, err := newRequest(info.FullMethod)
+	if err != nil {
+		return err
+	}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	if res := checkSession(ctx, info.FullMethod, req); res != nil {
+		return sendResult(stream, info.FullMethod, res)
+	}
+	return handler(srv, &preReadStream{ServerStream: stream, req: req})
+}
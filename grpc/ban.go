@@ -0,0 +1,253 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+	"StealthIMGroupUser/user"
+)
+
+// banActive 封禁仍然生效的 SQL 条件
+const banActive = "(`expire_time` = 0 OR `expire_time` > UNIX_TIMESTAMP())"
+
+const banColumns = "`id`, `uid`, `username`, `operator_uid`, `reason`, `expire_time`, `create_time`"
+
+// checkBanned 检查用户是否在群组封禁名单中
+func checkBanned(groupID int32, uid int32) *pb.Result {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT 1 FROM `group_ban` WHERE `groupid` = ? AND `uid` = ? AND " + banActive,
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: uid}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)}
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg}
+	}
+	if len(sqlResp.Data) > 0 {
+		return &pb.Result{Code: errorcode.GroupUserBanned, Msg: "User is banned"}
+	}
+	return nil
+}
+
+// checkBanOperator 检查生效中的封禁是否由身份更高的成员设置，返回设置者 uid，无生效封禁时为 0
+// 设置者已不在群内时不再限制
+func checkBanOperator(ctx context.Context, self *pb.MemberObject, members []*pb.MemberObject, groupID int32, username string) (int32, *pb.Result) {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT `operator_uid` FROM `group_ban` WHERE `groupid` = ? AND `username` = ? AND " + banActive,
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: username}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return 0, &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)}
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return 0, &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg}
+	}
+	if len(sqlResp.Data) == 0 || len(sqlResp.Data[0].Result) == 0 {
+		return 0, nil
+	}
+	operatorUID := sqlResp.Data[0].Result[0].GetInt32()
+	operatorName, err := user.QueryUsernameByUID(ctx, operatorUID)
+	if err != nil {
+		return 0, &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)}
+	}
+	if operator := findMember(members, operatorName); operator != nil && operator.Name != self.Name && outranks(operator, self) {
+		return 0, &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"}
+	}
+	return operatorUID, nil
+}
+
+// BanMember 封禁用户，若用户在群内则同时踢出
+func (s *server) BanMember(ctx context.Context, req *pb.BanMemberRequest) (*pb.BanMemberResponse, error) {
+	if utf8.RuneCountInString(req.Reason) > maxTextLength {
+		return &pb.BanMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Reason too long"},
+		}, nil
+	}
+	if req.ExpiresAt != 0 && req.ExpiresAt <= time.Now().Unix() {
+		return &pb.BanMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Invalid expiry"},
+		}, nil
+	}
+	self, members := actorOf(ctx)
+	if self.Name == req.Username {
+		return &pb.BanMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
+		}, nil
+	}
+	// 群内成员只能由身份更高者封禁，群外用户可直接封禁
+	target := findMember(members, req.Username)
+	if target != nil && !outranks(self, target) {
+		return &pb.BanMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
+		}, nil
+	}
+	// 不能覆盖身份更高者设置的封禁，否则可借此改为自己设置后解除
+	if _, res := checkBanOperator(ctx, self, members, req.GroupId, req.Username); res != nil {
+		return &pb.BanMemberResponse{Result: res}, nil
+	}
+	targetUID, err := user.QueryUIDByUsername(ctx, req.Username)
+	if err != nil {
+		return &pb.BanMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "User not found"},
+		}, nil
+	}
+
+	// 先写入封禁再踢出，避免踢出后立即重新加入
+	insertReq := &pb_gtw.SqlRequest{
+		Sql: "INSERT INTO `group_ban` (`groupid`, `uid`, `username`, `operator_uid`, `reason`, `expire_time`) VALUES (?, ?, ?, ?, ?, ?) " +
+			"ON DUPLICATE KEY UPDATE `operator_uid` = VALUES(`operator_uid`), `reason` = VALUES(`reason`), `expire_time` = VALUES(`expire_time`)",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: targetUID}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: req.Username}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.Uid}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: req.Reason}},
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: req.ExpiresAt}},
+		},
+	}
+	insertResp, err := gateway.ExecSQL(insertReq)
+	if err != nil {
+		return &pb.BanMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Insert error: %v", err)},
+		}, nil
+	}
+	if insertResp.Result.Code != errorcode.Success {
+		return &pb.BanMemberResponse{
+			Result: &pb.Result{Code: insertResp.Result.Code, Msg: insertResp.Result.Msg},
+		}, nil
+	}
+
+	// 群内成员经由踢出流程清理禁言，群外用户直接清理
+	if target == nil {
+		clearMute(req.GroupId, targetUID)
+	}
+	if target != nil {
+		kickResp, err := s.KickUser(ctx, &pb.KickUserRequest{GroupId: req.GroupId, Uid: req.Uid, Username: req.Username})
+		if err != nil {
+			return &pb.BanMemberResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserInternalError, Msg: fmt.Sprintf("Kick error: %v", err)},
+			}, nil
+		}
+		if kickResp.Result.Code != errorcode.Success && kickResp.Result.Code != errorcode.GroupUserNotFound {
+			return &pb.BanMemberResponse{Result: kickResp.Result}, nil
+		}
+	}
+	return &pb.BanMemberResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
+
+// UnbanMember 解除封禁，不能解除身份更高者设置的封禁
+func (s *server) UnbanMember(ctx context.Context, req *pb.UnbanMemberRequest) (*pb.UnbanMemberResponse, error) {
+	self, members := actorOf(ctx)
+	operatorUID, res := checkBanOperator(ctx, self, members, req.GroupId, req.Username)
+	if res != nil {
+		return &pb.UnbanMemberResponse{Result: res}, nil
+	}
+	if operatorUID == 0 {
+		return &pb.UnbanMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Ban not found"},
+		}, nil
+	}
+	// 限定设置者，避免检查后封禁被他人改写
+	deleteReq := &pb_gtw.SqlRequest{
+		Sql:    "DELETE FROM `group_ban` WHERE `groupid` = ? AND `username` = ? AND `operator_uid` = ? AND " + banActive,
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: req.Username}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: operatorUID}},
+		},
+		GetRowCount: true,
+	}
+	deleteResp, err := gateway.ExecSQL(deleteReq)
+	if err != nil {
+		return &pb.UnbanMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Delete error: %v", err)},
+		}, nil
+	}
+	if deleteResp.Result.Code != errorcode.Success {
+		return &pb.UnbanMemberResponse{
+			Result: &pb.Result{Code: deleteResp.Result.Code, Msg: deleteResp.Result.Msg},
+		}, nil
+	}
+	if deleteResp.RowsAffected == 0 {
+		return &pb.UnbanMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Ban not found"},
+		}, nil
+	}
+	return &pb.UnbanMemberResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
+
+// ListBans 分页获取群组生效中的封禁
+func (s *server) ListBans(ctx context.Context, req *pb.ListBansRequest) (*pb.ListBansResponse, error) {
+	limit := normalizeLimit(req.Limit)
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT " + banColumns + " FROM `group_ban` " +
+			"WHERE `groupid` = ? AND " + banActive + " AND `id` > ? ORDER BY `id` LIMIT ?",
+		Db: pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: req.Cursor}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: limit}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return &pb.ListBansResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return &pb.ListBansResponse{
+			Result: &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg},
+		}, nil
+	}
+	var bans []*pb.BanObject
+	nextCursor := int64(0)
+	for _, row := range sqlResp.Data {
+		if len(row.Result) < 7 {
+			continue
+		}
+		nextCursor = row.Result[0].GetInt64()
+		bans = append(bans, &pb.BanObject{
+			GroupId:     req.GroupId,
+			Uid:         row.Result[1].GetInt32(),
+			Username:    row.Result[2].GetStr(),
+			OperatorUid: row.Result[3].GetInt32(),
+			Reason:      row.Result[4].GetStr(),
+			ExpiresAt:   row.Result[5].GetInt64(),
+			CreatedAt:   row.Result[6].GetInt64(),
+		})
+	}
+	if len(bans) < int(limit) {
+		nextCursor = 0
+	}
+	return &pb.ListBansResponse{
+		Result:     &pb.Result{Code: errorcode.Success},
+		Bans:       bans,
+		NextCursor: nextCursor,
+	}, nil
+}
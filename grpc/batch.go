@@ -0,0 +1,128 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/user"
+)
+
+// BatchGetGroupPublicInfo 批量获取群组公开信息，结果与请求顺序一致
+func (s *server) BatchGetGroupPublicInfo(ctx context.Context, req *pb.BatchGetGroupPublicInfoRequest) (*pb.BatchGetGroupPublicInfoResponse, error) {
+	if len(req.GroupIds) > int(maxPageLimit) {
+		return &pb.BatchGetGroupPublicInfoResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Too many groups"},
+		}, nil
+	}
+
+	// 网关不支持多键读取，逐个读取缓存后将未命中的群组合并为一次查询
+	cacheObjs := map[int32]*pb.GetGroupPublicInfoCache{}
+	var misses []int32
+	for _, groupID := range req.GroupIds {
+		if _, ok := cacheObjs[groupID]; ok {
+			continue
+		}
+		if cacheObj := readGroupPublicCache(groupID); cacheObj != nil {
+			cacheObjs[groupID] = cacheObj
+		} else {
+			cacheObjs[groupID] = nil
+			misses = append(misses, groupID)
+		}
+	}
+	var queryErr error
+	if len(misses) > 0 {
+		queried, err := queryGroupPublicInfos(misses)
+		queryErr = err
+		for groupID, cacheObj := range queried {
+			cacheObjs[groupID] = cacheObj
+		}
+	}
+
+	results := make([]*pb.GetGroupPublicInfoResponse, 0, len(req.GroupIds))
+	for _, groupID := range req.GroupIds {
+		cacheObj := cacheObjs[groupID]
+		switch {
+		case cacheObj == nil:
+			results = append(results, &pb.GetGroupPublicInfoResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", queryErr)},
+				Id:     groupID,
+			})
+		case cacheObj.Id == -1:
+			results = append(results, &pb.GetGroupPublicInfoResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Group not found"},
+				Id:     groupID,
+			})
+		default:
+			results = append(results, &pb.GetGroupPublicInfoResponse{
+				Result:      &pb.Result{Code: errorcode.Success},
+				Id:          cacheObj.Id,
+				Name:        cacheObj.Name,
+				CreatedAt:   cacheObj.CreatedAt,
+				JoinPolicy:  cacheObj.JoinPolicy,
+				MemberCount: cacheObj.MemberCount,
+			})
+		}
+	}
+	return &pb.BatchGetGroupPublicInfoResponse{
+		Result:  &pb.Result{Code: errorcode.Success},
+		Results: results,
+	}, nil
+}
+
+// BatchGetGroupsByUID 批量获取用户加入的群组列表，结果与请求顺序一致
+func (s *server) BatchGetGroupsByUID(ctx context.Context, req *pb.BatchGetGroupsByUIDRequest) (*pb.BatchGetGroupsByUIDResponse, error) {
+	if len(req.Uids) > int(maxPageLimit) {
+		return &pb.BatchGetGroupsByUIDResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Too many users"},
+		}, nil
+	}
+
+	results := make([]*pb.GetGroupsByUIDResponse, len(req.Uids))
+	usernames := map[int32]string{}
+	var misses []string
+	for i, uid := range req.Uids {
+		if cacheObj := readUserGroupsCache(uid); cacheObj != nil {
+			results[i] = &pb.GetGroupsByUIDResponse{Result: &pb.Result{Code: errorcode.Success}, Groups: cacheObj.Groups}
+			continue
+		}
+		if _, ok := usernames[uid]; ok {
+			continue
+		}
+		username, err := user.QueryUsernameByUID(ctx, uid)
+		if err != nil {
+			results[i] = &pb.GetGroupsByUIDResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+			}
+			continue
+		}
+		usernames[uid] = username
+		misses = append(misses, username)
+	}
+	if len(misses) == 0 {
+		return &pb.BatchGetGroupsByUIDResponse{
+			Result:  &pb.Result{Code: errorcode.Success},
+			Results: results,
+		}, nil
+	}
+
+	cacheObjs, res := queryUserGroups(misses)
+	for i, uid := range req.Uids {
+		username, ok := usernames[uid]
+		if results[i] != nil || !ok {
+			continue
+		}
+		if res != nil {
+			results[i] = &pb.GetGroupsByUIDResponse{Result: res}
+			continue
+		}
+		cacheObj := cacheObjs[username]
+		writeUserGroupsCache(uid, cacheObj)
+		results[i] = &pb.GetGroupsByUIDResponse{Result: &pb.Result{Code: errorcode.Success}, Groups: cacheObj.Groups}
+	}
+	return &pb.BatchGetGroupsByUIDResponse{
+		Result:  &pb.Result{Code: errorcode.Success},
+		Results: results,
+	}, nil
+}
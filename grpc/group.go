@@ -0,0 +1,325 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/config"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/event"
+	"StealthIMGroupUser/gateway"
+	"StealthIMGroupUser/outbox"
+	"StealthIMGroupUser/user"
+)
+
+// dissolveCleanupSQL 解散群组后清理的关联数据，回调投递记录需先于回调删除
+var dissolveCleanupSQL = []string{
+	"DELETE FROM `group_invite_link` WHERE `groupid` = ?",
+	"DELETE FROM `group_invitation` WHERE `groupid` = ?",
+	"DELETE FROM `group_join_request` WHERE `groupid` = ?",
+	"DELETE FROM `group_ban` WHERE `groupid` = ?",
+	"DELETE FROM `group_mute` WHERE `groupid` = ?",
+	"DELETE FROM `group_role` WHERE `groupid` = ?",
+	"DELETE FROM `group_permission` WHERE `groupid` = ?",
+	"DELETE t1 FROM `group_webhook_delivery` AS t1 JOIN `group_webhook` AS t2 ON t2.`id` = t1.`webhook_id` WHERE t2.`groupid` = ?",
+	"DELETE FROM `group_webhook` WHERE `groupid` = ?",
+}
+
+// dissolveGroup 删除群组、全部成员及关联数据并清理缓存
+func dissolveGroup(groupID int32, actorUID int32, members []*pb.MemberObject) error {
+	deleteReq := &pb_gtw.SqlRequest{
+		Sql:    "DELETE t1, t2 FROM `groups` AS t1 LEFT JOIN `group_user_table` AS t2 ON t2.groupid = t1.groupid WHERE t1.groupid = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+		},
+	}
+	ev := newGroupEvent(groupID, pb.GroupEventType_group_dissolved, actorUID, "", "", "")
+	outbox.Attach(deleteReq, ev)
+	deleteResp, err := gateway.ExecSQL(deleteReq)
+	if err != nil {
+		return err
+	}
+	if deleteResp.Result.Code != errorcode.Success {
+		return fmt.Errorf("[%d]%s", deleteResp.Result.Code, deleteResp.Result.Msg)
+	}
+
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", groupID)})
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:public:" + fmt.Sprintf("%d", groupID)})
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:password:" + fmt.Sprintf("%d", groupID)})
+	// 群组与成员已删除，新成员与关联数据无法再写入，清理失败只留下不可达的记录
+	for _, sql := range dissolveCleanupSQL {
+		cleanupResp, err := gateway.ExecSQL(&pb_gtw.SqlRequest{
+			Sql:    sql,
+			Db:     pb_gtw.SqlDatabases_Groups,
+			Commit: true,
+			Params: []*pb_gtw.InterFaceType{
+				{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+			},
+		})
+		if err != nil {
+			log.Printf("[GRPC]Dissolve cleanup error: %v\n", err)
+		} else if cleanupResp.Result.Code != errorcode.Success {
+			log.Printf("[GRPC]Dissolve cleanup error: [%d]%s\n", cleanupResp.Result.Code, cleanupResp.Result.Msg)
+		}
+	}
+
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:permission:" + fmt.Sprintf("%d", groupID)})
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:roles:" + fmt.Sprintf("%d", groupID)})
+	event.Publish(ev)
+	go func() {
+		for _, element := range members {
+			delMemberCache(groupID, element.Name)
+			delUserGroupsCache(context.Background(), element.Name)
+		}
+	}()
+	return nil
+}
+
+// swapOwner 将群主转让给目标成员，原群主降为管理员，并在同一请求中写入事件
+func swapOwner(groupID int32, fromUsername string, fromUID int32, toUsername string, toUID int32, ev *pb.GroupEvent) (*pb_gtw.SqlResponse, error) {
+	// 单条语句同时交换双方身份并更新 owner_uid，保证任意时刻只有一个群主
+	updateReq := &pb_gtw.SqlRequest{
+		Sql: "UPDATE `group_user_table` AS t1, `groups` AS t2 " +
+			"SET t1.`type` = IF(t1.`username` = ?, 'owner', 'manager'), t2.`owner_uid` = ? " +
+			"WHERE t1.`groupid` = ? AND t1.`username` IN (?, ?) AND t2.`groupid` = t1.`groupid` AND t2.`owner_uid` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Str{Str: toUsername}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: toUID}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: fromUsername}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: toUsername}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: fromUID}},
+		},
+		GetRowCount: true,
+	}
+	outbox.Attach(updateReq, ev)
+	return gateway.ExecSQL(updateReq)
+}
+
+// DissolveGroup 解散群组
+func (s *server) DissolveGroup(ctx context.Context, req *pb.DissolveGroupRequest) (*pb.DissolveGroupResponse, error) {
+	// 解散需要完整成员列表以清理缓存，授权时已从数据库读取
+	_, members := actorOf(ctx)
+	if err := dissolveGroup(req.GroupId, req.Uid, members); err != nil {
+		return &pb.DissolveGroupResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Delete error: %v", err)},
+		}, nil
+	}
+	return &pb.DissolveGroupResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
+
+// TransferOwnership 转让群主
+func (s *server) TransferOwnership(ctx context.Context, req *pb.TransferOwnershipRequest) (*pb.TransferOwnershipResponse, error) {
+	// 群主身份以数据库为准，避免依据过期缓存转让
+	self, members := actorOf(ctx)
+	username := self.Name
+	if req.ToUsername == username {
+		return &pb.TransferOwnershipResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Cannot transfer ownership to yourself"},
+		}, nil
+	}
+	target := findMember(members, req.ToUsername)
+	if target == nil {
+		return &pb.TransferOwnershipResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "User not found"},
+		}, nil
+	}
+	if target.Type == pb.MemberType_owner {
+		return &pb.TransferOwnershipResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
+		}, nil
+	}
+
+	toUID, err := user.QueryUIDByUsername(ctx, req.ToUsername)
+	if err != nil {
+		return &pb.TransferOwnershipResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+		}, nil
+	}
+
+	ev := newGroupEvent(req.GroupId, pb.GroupEventType_owner_transferred, req.FromUid, req.ToUsername, username, req.ToUsername)
+	updateResp, err := swapOwner(req.GroupId, username, req.FromUid, req.ToUsername, toUID, ev)
+	if err != nil {
+		return &pb.TransferOwnershipResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Update error: %v", err)},
+		}, nil
+	}
+	if updateResp.Result.Code != errorcode.Success {
+		return &pb.TransferOwnershipResponse{
+			Result: &pb.Result{Code: updateResp.Result.Code, Msg: updateResp.Result.Msg},
+		}, nil
+	}
+	if updateResp.RowsAffected == 0 {
+		return &pb.TransferOwnershipResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Ownership has changed"},
+		}, nil
+	}
+
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
+	delMemberCache(req.GroupId, username, req.ToUsername)
+	event.Publish(ev)
+	return &pb.TransferOwnershipResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
+
+// querySuccessor 按继任策略选出接替群主的成员
+func querySuccessor(groupID int32, ownerUsername string) (string, error) {
+	// 按加入时间排序，记录加入时间之前的成员为空值排在最前
+	order := "FIELD(`type`, 'manager', 'member', 'other'), `joined_at`, `id`"
+	if config.LatestConfig.Group.Succession == "member" {
+		order = "(`type` = 'other'), `joined_at`, `id`"
+	}
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT `username` FROM `group_user_table` WHERE `groupid` = ? AND `username` <> ? ORDER BY " + order + " LIMIT 1",
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: ownerUsername}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return "", err
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return "", fmt.Errorf("[%d]%s", sqlResp.Result.Code, sqlResp.Result.Msg)
+	}
+	if len(sqlResp.Data) == 0 || len(sqlResp.Data[0].Result) == 0 {
+		return "", nil
+	}
+	return sqlResp.Data[0].Result[0].GetStr(), nil
+}
+
+// LeaveGroup 用户退出群组
+func (s *server) LeaveGroup(ctx context.Context, req *pb.LeaveGroupRequest) (*pb.LeaveGroupResponse, error) {
+	self, members := actorOf(ctx)
+	username := self.Name
+	if self.Type == pb.MemberType_owner {
+		successor := ""
+		if config.LatestConfig.Group.Succession != "dissolve" {
+			var err error
+			successor, err = querySuccessor(req.GroupId, username)
+			if err != nil {
+				return &pb.LeaveGroupResponse{
+					Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+				}, nil
+			}
+		}
+		if successor == "" {
+			if err := dissolveGroup(req.GroupId, req.Uid, members); err != nil {
+				return &pb.LeaveGroupResponse{
+					Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Delete error: %v", err)},
+				}, nil
+			}
+			return &pb.LeaveGroupResponse{
+				Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+			}, nil
+		}
+		successorUID, err := user.QueryUIDByUsername(ctx, successor)
+		if err != nil {
+			return &pb.LeaveGroupResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+			}, nil
+		}
+		// 先完成转让再删除原群主，保证群组不会出现无群主的状态
+		swapEvent := newGroupEvent(req.GroupId, pb.GroupEventType_owner_transferred, req.Uid, successor, username, successor)
+		swapResp, err := swapOwner(req.GroupId, username, req.Uid, successor, successorUID, swapEvent)
+		if err != nil {
+			return &pb.LeaveGroupResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Update error: %v", err)},
+			}, nil
+		}
+		if swapResp.Result.Code != errorcode.Success || swapResp.RowsAffected == 0 {
+			return &pb.LeaveGroupResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: "Ownership succession failed"},
+			}, nil
+		}
+		delMemberCache(req.GroupId, successor)
+		event.Publish(swapEvent)
+	}
+
+	deleteReq := &pb_gtw.SqlRequest{
+		Sql:    "DELETE FROM `group_user_table` WHERE `groupid` = ? AND `username` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: username}},
+		},
+	}
+	ev := newGroupEvent(req.GroupId, pb.GroupEventType_member_left, req.Uid, username, "", "")
+	outbox.Attach(deleteReq, ev)
+	deleteResp, err := gateway.ExecSQL(deleteReq)
+	if err != nil {
+		return &pb.LeaveGroupResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Delete error: %v", err)},
+		}, nil
+	}
+	if deleteResp.Result.Code != errorcode.Success {
+		return &pb.LeaveGroupResponse{
+			Result: &pb.Result{Code: deleteResp.Result.Code, Msg: deleteResp.Result.Msg},
+		}, nil
+	}
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:groups:" + fmt.Sprintf("%d", req.Uid)})
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:public:" + fmt.Sprintf("%d", req.GroupId)})
+	delMemberCache(req.GroupId, username)
+	clearMute(req.GroupId, req.Uid)
+	event.Publish(ev)
+	return &pb.LeaveGroupResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
+
+// SetJoinPolicy 设置群组加入策略
+func (s *server) SetJoinPolicy(ctx context.Context, req *pb.SetJoinPolicyRequest) (*pb.SetJoinPolicyResponse, error) {
+	// 未设置密码时不能切换为密码制，否则无人能够加入
+	if req.JoinPolicy == pb.JoinPolicy_password {
+		storedPasswordHash, err := loadGroupPasswordHash(req.GroupId)
+		if err != nil {
+			return &pb.SetJoinPolicyResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+			}, nil
+		}
+		if storedPasswordHash == "" {
+			return &pb.SetJoinPolicyResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Password not set"},
+			}, nil
+		}
+	}
+
+	updateReq := &pb_gtw.SqlRequest{
+		Sql:    "UPDATE `groups` SET `join_policy` = ? WHERE `groupid` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Str{Str: convertProtoToSQLJoinPolicy(req.JoinPolicy)}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+		},
+	}
+	updateResp, err := gateway.ExecSQL(updateReq)
+	if err != nil {
+		return &pb.SetJoinPolicyResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Update error: %v", err)},
+		}, nil
+	}
+	if updateResp.Result.Code != errorcode.Success {
+		return &pb.SetJoinPolicyResponse{
+			Result: &pb.Result{Code: updateResp.Result.Code, Msg: updateResp.Result.Msg},
+		}, nil
+	}
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:public:" + fmt.Sprintf("%d", req.GroupId)})
+	return &pb.SetJoinPolicyResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
@@ -2,6 +2,7 @@ package grpc
 
 import (
 	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/cert"
 	"StealthIMGroupUser/config"
 	"context"
 	"log"
@@ -28,8 +29,23 @@ func Start(rCfg config.Config) {
 	if err != nil {
 		log.Fatalf("[GRPC]Failed to listen: %v", err)
 	}
-	s := grpc.NewServer()
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(authUnaryInterceptor, authzUnaryInterceptor),
+		grpc.ChainStreamInterceptor(authStreamInterceptor, authzStreamInterceptor),
+	}
+	if rCfg.GRPCProxy.TLS.Cert != "" {
+		creds, err := cert.ServerCredentials(rCfg.GRPCProxy.TLS)
+		if err != nil {
+			log.Fatalf("[GRPC]Failed to load certificate: %v", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+		log.Printf("[GRPC]TLS enabled, mTLS: %v", rCfg.GRPCProxy.TLS.ClientCA != "")
+	}
+	s := grpc.NewServer(opts...)
 	pb.RegisterStealthIMGroupUserServer(s, &server{})
+	if missing := missingPolicies(s); len(missing) > 0 {
+		log.Fatalf("[GRPC]Methods without policy: %v", missing)
+	}
 	log.Printf("[GRPC]Server listening at %v", lis.Addr())
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("[GRPC]Failed to serve: %v", err)
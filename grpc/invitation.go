@@ -0,0 +1,219 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+	"StealthIMGroupUser/user"
+)
+
+// maxPendingInvitations 单个用户最多保留的待处理邀请数
+const maxPendingInvitations = 100
+
+const invitationColumns = "`id`, `groupid`, `inviter_uid`, CAST(`status` AS CHAR), `create_time`"
+
+// parseInvitations 解析邀请查询结果
+func parseInvitations(sqlResp *pb_gtw.SqlResponse) []*pb.InvitationObject {
+	var invitations []*pb.InvitationObject
+	for _, row := range sqlResp.Data {
+		if len(row.Result) < 5 {
+			continue
+		}
+		invitations = append(invitations, &pb.InvitationObject{
+			Id:         row.Result[0].GetInt64(),
+			GroupId:    row.Result[1].GetInt32(),
+			InviterUid: row.Result[2].GetInt32(),
+			Status:     convertSQLRequestStatusToProto(row.Result[3].GetStr()),
+			CreatedAt:  row.Result[4].GetInt64(),
+		})
+	}
+	return invitations
+}
+
+// insertInvitation 创建待被邀请者确认的邀请
+func insertInvitation(groupID int32, inviterUID int32, inviteeUID int32, inviteeUsername string) (int64, *pb.Result) {
+	// 同一群组对同一用户只保留一条待处理邀请，且限制单个用户的待处理邀请数
+	insertReq := &pb_gtw.SqlRequest{
+		Sql: "INSERT INTO `group_invitation` (`groupid`, `inviter_uid`, `invitee_uid`, `invitee_username`, `status`) " +
+			"SELECT ?, ?, ?, ?, 'pending' FROM DUAL WHERE NOT EXISTS " +
+			"(SELECT 1 FROM `group_invitation` WHERE `groupid` = ? AND `invitee_uid` = ? AND `status` = 'pending') " +
+			"AND (SELECT COUNT(*) FROM `group_invitation` WHERE `invitee_uid` = ? AND `status` = 'pending') < ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: inviterUID}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: inviteeUID}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: inviteeUsername}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: inviteeUID}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: inviteeUID}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: maxPendingInvitations}},
+		},
+		GetRowCount:     true,
+		GetLastInsertId: true,
+	}
+	insertResp, err := gateway.ExecSQL(insertReq)
+	if err != nil {
+		return 0, &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Insert error: %v", err)}
+	}
+	if insertResp.Result.Code != errorcode.Success {
+		return 0, &pb.Result{Code: insertResp.Result.Code, Msg: insertResp.Result.Msg}
+	}
+	if insertResp.RowsAffected == 0 {
+		return 0, &pb.Result{Code: errorcode.GroupUserRequestExists, Msg: "Invitation already exists"}
+	}
+	return insertResp.LastInsertId, nil
+}
+
+// ListMyInvitations 获取用户待处理的入群邀请
+func (s *server) ListMyInvitations(ctx context.Context, req *pb.ListMyInvitationsRequest) (*pb.ListMyInvitationsResponse, error) {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT " + invitationColumns + " FROM `group_invitation` WHERE `invitee_uid` = ? AND `status` = 'pending' ORDER BY `id` DESC LIMIT ?",
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.Uid}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: maxPendingInvitations}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return &pb.ListMyInvitationsResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return &pb.ListMyInvitationsResponse{
+			Result: &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg},
+		}, nil
+	}
+	return &pb.ListMyInvitationsResponse{
+		Result:      &pb.Result{Code: errorcode.Success},
+		Invitations: parseInvitations(sqlResp),
+	}, nil
+}
+
+// resolveInvitation 将用户的待处理邀请标记为指定状态，返回邀请内容
+func resolveInvitation(uid int32, invitationID int64, status string) (*pb.InvitationObject, *pb.Result) {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT " + invitationColumns + " FROM `group_invitation` WHERE `id` = ? AND `invitee_uid` = ? AND `status` = 'pending'",
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: invitationID}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: uid}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return nil, &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)}
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return nil, &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg}
+	}
+	invitations := parseInvitations(sqlResp)
+	if len(invitations) == 0 {
+		return nil, &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Invitation not found"}
+	}
+
+	updateReq := &pb_gtw.SqlRequest{
+		Sql:    "UPDATE `group_invitation` SET `status` = ? WHERE `id` = ? AND `status` = 'pending'",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Str{Str: status}},
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: invitationID}},
+		},
+		GetRowCount: true,
+	}
+	updateResp, err := gateway.ExecSQL(updateReq)
+	if err != nil {
+		return nil, &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Update error: %v", err)}
+	}
+	if updateResp.Result.Code != errorcode.Success {
+		return nil, &pb.Result{Code: updateResp.Result.Code, Msg: updateResp.Result.Msg}
+	}
+	if updateResp.RowsAffected == 0 {
+		return nil, &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Invitation not found"}
+	}
+	return invitations[0], nil
+}
+
+// AcceptInvitation 接受入群邀请
+func (s *server) AcceptInvitation(ctx context.Context, req *pb.AcceptInvitationRequest) (*pb.AcceptInvitationResponse, error) {
+	username, err := user.QueryUsernameByUID(ctx, req.Uid)
+	if err != nil {
+		return &pb.AcceptInvitationResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+		}, nil
+	}
+	invitation, res := resolveInvitation(req.Uid, req.InvitationId, "approved")
+	if res != nil {
+		return &pb.AcceptInvitationResponse{Result: res}, nil
+	}
+	if res := insertGroupMember(invitation.GroupId, username, req.Uid, invitation.InviterUid, pb.JoinMethod_invite); res.Code != errorcode.Success {
+		// 加入失败时恢复邀请状态，以便重新处理
+		if res.Code != errorcode.GroupUserAlreadyInGroup {
+			revertResp, err := gateway.ExecSQL(&pb_gtw.SqlRequest{
+				Sql:    "UPDATE `group_invitation` SET `status` = 'pending' WHERE `id` = ?",
+				Db:     pb_gtw.SqlDatabases_Groups,
+				Commit: true,
+				Params: []*pb_gtw.InterFaceType{
+					{Response: &pb_gtw.InterFaceType_Int64{Int64: req.InvitationId}},
+				},
+			})
+			if err != nil {
+				log.Printf("[GRPC]Revert invitation error: %v\n", err)
+			} else if revertResp.Result.Code != errorcode.Success {
+				log.Printf("[GRPC]Revert invitation error: [%d]%s\n", revertResp.Result.Code, revertResp.Result.Msg)
+			}
+		}
+		return &pb.AcceptInvitationResponse{Result: res}, nil
+	}
+	return &pb.AcceptInvitationResponse{
+		Result:  &pb.Result{Code: errorcode.Success, Msg: ""},
+		GroupId: invitation.GroupId,
+	}, nil
+}
+
+// DeclineInvitation 拒绝入群邀请
+func (s *server) DeclineInvitation(ctx context.Context, req *pb.DeclineInvitationRequest) (*pb.DeclineInvitationResponse, error) {
+	if _, res := resolveInvitation(req.Uid, req.InvitationId, "rejected"); res != nil {
+		return &pb.DeclineInvitationResponse{Result: res}, nil
+	}
+	return &pb.DeclineInvitationResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
+
+// SetDirectInvite 设置群组是否允许直接拉人入群
+func (s *server) SetDirectInvite(ctx context.Context, req *pb.SetDirectInviteRequest) (*pb.SetDirectInviteResponse, error) {
+	updateReq := &pb_gtw.SqlRequest{
+		Sql:    "UPDATE `groups` SET `is_direct_invite` = ? WHERE `groupid` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: boolToInt32(req.IsDirectInvite)}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+		},
+	}
+	updateResp, err := gateway.ExecSQL(updateReq)
+	if err != nil {
+		return &pb.SetDirectInviteResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Update error: %v", err)},
+		}, nil
+	}
+	if updateResp.Result.Code != errorcode.Success {
+		return &pb.SetDirectInviteResponse{
+			Result: &pb.Result{Code: updateResp.Result.Code, Msg: updateResp.Result.Msg},
+		}, nil
+	}
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:public:" + fmt.Sprintf("%d", req.GroupId)})
+	return &pb.SetDirectInviteResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
@@ -0,0 +1,267 @@
+package grpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+	"StealthIMGroupUser/user"
+)
+
+const (
+	// maxInviteLinks 单个群组最多同时存在的有效邀请链接数
+	maxInviteLinks = 100
+	// inviteTokenLength 邀请令牌的十六进制长度
+	inviteTokenLength = 32
+)
+
+// inviteLinkUsable 邀请链接仍可使用的 SQL 条件
+const inviteLinkUsable = "`is_revoked` = 0 AND (`expire_time` = 0 OR `expire_time` > UNIX_TIMESTAMP()) AND (`max_uses` = 0 OR `used_count` < `max_uses`)"
+
+// newInviteToken 生成随机邀请令牌
+func newInviteToken() (string, error) {
+	buf := make([]byte, inviteTokenLength/2)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// validInviteToken 检查邀请令牌格式
+func validInviteToken(token string) bool {
+	if len(token) != inviteTokenLength {
+		return false
+	}
+	_, err := hex.DecodeString(token)
+	return err == nil
+}
+
+// CreateInviteLink 创建邀请链接
+func (s *server) CreateInviteLink(ctx context.Context, req *pb.CreateInviteLinkRequest) (*pb.CreateInviteLinkResponse, error) {
+	if req.MaxUses < 0 || (req.ExpiresAt != 0 && req.ExpiresAt <= time.Now().Unix()) {
+		return &pb.CreateInviteLinkResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Invalid max uses or expiry"},
+		}, nil
+	}
+	token, err := newInviteToken()
+	if err != nil {
+		return &pb.CreateInviteLinkResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInternalError, Msg: fmt.Sprintf("Token error: %v", err)},
+		}, nil
+	}
+	// 有效链接数量达到上限时拒绝创建
+	insertReq := &pb_gtw.SqlRequest{
+		Sql: "INSERT INTO `group_invite_link` (`groupid`, `token`, `creator_uid`, `max_uses`, `used_count`, `expire_time`, `is_revoked`) " +
+			"SELECT ?, ?, ?, ?, 0, ?, 0 FROM DUAL WHERE (SELECT COUNT(*) FROM `group_invite_link` WHERE `groupid` = ? AND " + inviteLinkUsable + ") < ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: token}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.Uid}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.MaxUses}},
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: req.ExpiresAt}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: maxInviteLinks}},
+		},
+		GetRowCount: true,
+	}
+	insertResp, err := gateway.ExecSQL(insertReq)
+	if err != nil {
+		return &pb.CreateInviteLinkResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Insert error: %v", err)},
+		}, nil
+	}
+	if insertResp.Result.Code != errorcode.Success {
+		return &pb.CreateInviteLinkResponse{
+			Result: &pb.Result{Code: insertResp.Result.Code, Msg: insertResp.Result.Msg},
+		}, nil
+	}
+	if insertResp.RowsAffected == 0 {
+		return &pb.CreateInviteLinkResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Too many invite links"},
+		}, nil
+	}
+	return &pb.CreateInviteLinkResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+		Token:  token,
+	}, nil
+}
+
+// RevokeInviteLink 撤销邀请链接
+func (s *server) RevokeInviteLink(ctx context.Context, req *pb.RevokeInviteLinkRequest) (*pb.RevokeInviteLinkResponse, error) {
+	if !validInviteToken(req.Token) {
+		return &pb.RevokeInviteLinkResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInviteLinkInvalid, Msg: "Invite link invalid"},
+		}, nil
+	}
+	updateReq := &pb_gtw.SqlRequest{
+		Sql:    "UPDATE `group_invite_link` SET `is_revoked` = 1 WHERE `groupid` = ? AND `token` = ? AND `is_revoked` = 0",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: req.Token}},
+		},
+		GetRowCount: true,
+	}
+	updateResp, err := gateway.ExecSQL(updateReq)
+	if err != nil {
+		return &pb.RevokeInviteLinkResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Update error: %v", err)},
+		}, nil
+	}
+	if updateResp.Result.Code != errorcode.Success {
+		return &pb.RevokeInviteLinkResponse{
+			Result: &pb.Result{Code: updateResp.Result.Code, Msg: updateResp.Result.Msg},
+		}, nil
+	}
+	if updateResp.RowsAffected == 0 {
+		return &pb.RevokeInviteLinkResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Invite link not found"},
+		}, nil
+	}
+	return &pb.RevokeInviteLinkResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
+
+// ListInviteLinks 获取群组有效的邀请链接
+func (s *server) ListInviteLinks(ctx context.Context, req *pb.ListInviteLinksRequest) (*pb.ListInviteLinksResponse, error) {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT `token`, `creator_uid`, `max_uses`, `used_count`, `expire_time`, `create_time` FROM `group_invite_link` " +
+			"WHERE `groupid` = ? AND " + inviteLinkUsable + " ORDER BY `id` LIMIT ?",
+		Db: pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: maxInviteLinks}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return &pb.ListInviteLinksResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return &pb.ListInviteLinksResponse{
+			Result: &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg},
+		}, nil
+	}
+	var links []*pb.InviteLinkObject
+	for _, row := range sqlResp.Data {
+		if len(row.Result) < 6 {
+			continue
+		}
+		links = append(links, &pb.InviteLinkObject{
+			Token:      row.Result[0].GetStr(),
+			GroupId:    req.GroupId,
+			CreatorUid: row.Result[1].GetInt32(),
+			MaxUses:    row.Result[2].GetInt32(),
+			UsedCount:  row.Result[3].GetInt32(),
+			ExpiresAt:  row.Result[4].GetInt64(),
+			CreatedAt:  row.Result[5].GetInt64(),
+		})
+	}
+	return &pb.ListInviteLinksResponse{
+		Result: &pb.Result{Code: errorcode.Success},
+		Links:  links,
+	}, nil
+}
+
+// peekInviteLink 查询有效邀请链接对应的群组与创建者
+func peekInviteLink(token string) (int32, int32, *pb.Result) {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT `groupid`, `creator_uid` FROM `group_invite_link` WHERE `token` = ? AND " + inviteLinkUsable,
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Str{Str: token}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return 0, 0, &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)}
+	}
+	if sqlResp.Result.Code != errorcode.Success || len(sqlResp.Data) == 0 || len(sqlResp.Data[0].Result) < 2 {
+		return 0, 0, &pb.Result{Code: errorcode.GroupUserInviteLinkInvalid, Msg: "Invite link invalid"}
+	}
+	return sqlResp.Data[0].Result[0].GetInt32(), sqlResp.Data[0].Result[1].GetInt32(), nil
+}
+
+// consumeInviteLink 占用邀请链接的一次使用次数
+func consumeInviteLink(token string) *pb.Result {
+	// 计数与有效性判断在同一条语句中完成，避免并发超用
+	updateReq := &pb_gtw.SqlRequest{
+		Sql:    "UPDATE `group_invite_link` SET `used_count` = `used_count` + 1 WHERE `token` = ? AND " + inviteLinkUsable,
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Str{Str: token}},
+		},
+		GetRowCount: true,
+	}
+	updateResp, err := gateway.ExecSQL(updateReq)
+	if err != nil {
+		return &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Update error: %v", err)}
+	}
+	if updateResp.Result.Code != errorcode.Success || updateResp.RowsAffected == 0 {
+		return &pb.Result{Code: errorcode.GroupUserInviteLinkInvalid, Msg: "Invite link invalid"}
+	}
+	return nil
+}
+
+// RedeemInviteLink 通过邀请链接加入群组
+func (s *server) RedeemInviteLink(ctx context.Context, req *pb.RedeemInviteLinkRequest) (*pb.RedeemInviteLinkResponse, error) {
+	if !validInviteToken(req.Token) {
+		return &pb.RedeemInviteLinkResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInviteLinkInvalid, Msg: "Invite link invalid"},
+		}, nil
+	}
+	username, err := user.QueryUsernameByUID(ctx, req.Uid)
+	if err != nil {
+		return &pb.RedeemInviteLinkResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+		}, nil
+	}
+
+	groupID, creatorUID, res := peekInviteLink(req.Token)
+	if res != nil {
+		return &pb.RedeemInviteLinkResponse{Result: res}, nil
+	}
+	// 被封禁的用户不占用链接次数
+	if res := checkBanned(groupID, req.Uid); res != nil {
+		return &pb.RedeemInviteLinkResponse{Result: res}, nil
+	}
+	if res := consumeInviteLink(req.Token); res != nil {
+		return &pb.RedeemInviteLinkResponse{Result: res}, nil
+	}
+	// 链接使用凭证代替密码校验，但仍受人数上限约束
+	if res := insertGroupMember(groupID, username, req.Uid, creatorUID, pb.JoinMethod_link); res.Code != errorcode.Success {
+		// 加入失败时归还链接次数
+		revertResp, err := gateway.ExecSQL(&pb_gtw.SqlRequest{
+			Sql:    "UPDATE `group_invite_link` SET `used_count` = `used_count` - 1 WHERE `token` = ? AND `used_count` > 0",
+			Db:     pb_gtw.SqlDatabases_Groups,
+			Commit: true,
+			Params: []*pb_gtw.InterFaceType{
+				{Response: &pb_gtw.InterFaceType_Str{Str: req.Token}},
+			},
+		})
+		if err != nil {
+			log.Printf("[GRPC]Revert invite link error: %v\n", err)
+		} else if revertResp.Result.Code != errorcode.Success {
+			log.Printf("[GRPC]Revert invite link error: [%d]%s\n", revertResp.Result.Code, revertResp.Result.Msg)
+		}
+		return &pb.RedeemInviteLinkResponse{Result: res}, nil
+	}
+	return &pb.RedeemInviteLinkResponse{
+		Result:  &pb.Result{Code: errorcode.Success, Msg: ""},
+		GroupId: groupID,
+	}, nil
+}
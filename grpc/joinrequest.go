@@ -0,0 +1,284 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"unicode/utf8"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+	"StealthIMGroupUser/user"
+)
+
+const joinRequestColumns = "`id`, `groupid`, `uid`, `username`, `message`, CAST(`status` AS CHAR), `reason`, `create_time`"
+
+func convertSQLRequestStatusToProto(sqlStatus string) pb.RequestStatus {
+	switch sqlStatus {
+	case "approved":
+		return pb.RequestStatus_approved
+	case "rejected":
+		return pb.RequestStatus_rejected
+	default:
+		return pb.RequestStatus_pending
+	}
+}
+
+// parseJoinRequests 解析入群申请查询结果
+func parseJoinRequests(sqlResp *pb_gtw.SqlResponse) []*pb.JoinRequestObject {
+	var requests []*pb.JoinRequestObject
+	for _, row := range sqlResp.Data {
+		if len(row.Result) < 8 {
+			continue
+		}
+		requests = append(requests, &pb.JoinRequestObject{
+			Id:        row.Result[0].GetInt64(),
+			GroupId:   row.Result[1].GetInt32(),
+			Uid:       row.Result[2].GetInt32(),
+			Username:  row.Result[3].GetStr(),
+			Message:   row.Result[4].GetStr(),
+			Status:    convertSQLRequestStatusToProto(row.Result[5].GetStr()),
+			Reason:    row.Result[6].GetStr(),
+			CreatedAt: row.Result[7].GetInt64(),
+		})
+	}
+	return requests
+}
+
+// RequestJoin 申请加入审核制群组
+func (s *server) RequestJoin(ctx context.Context, req *pb.RequestJoinRequest) (*pb.RequestJoinResponse, error) {
+	if utf8.RuneCountInString(req.Message) > maxTextLength {
+		return &pb.RequestJoinResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Message too long"},
+		}, nil
+	}
+	publicObj, err := loadGroupPublicCache(req.GroupId)
+	if err != nil {
+		return &pb.RequestJoinResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	if publicObj.Id == -1 {
+		return &pb.RequestJoinResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Group not found"},
+		}, nil
+	}
+	if publicObj.JoinPolicy != pb.JoinPolicy_approval {
+		return &pb.RequestJoinResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Group does not require approval"},
+		}, nil
+	}
+
+	username, err := user.QueryUsernameByUID(ctx, req.Uid)
+	if err != nil {
+		return &pb.RequestJoinResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+		}, nil
+	}
+	cacheObj, err := loadGroupInfoCache(req.GroupId)
+	if err == nil && findMember(cacheObj.Members, username) != nil {
+		return &pb.RequestJoinResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserAlreadyInGroup, Msg: "User already in group"},
+		}, nil
+	}
+	if res := checkBanned(req.GroupId, req.Uid); res != nil {
+		return &pb.RequestJoinResponse{Result: res}, nil
+	}
+
+	// 同一用户在同一群组只保留一条待处理申请
+	insertReq := &pb_gtw.SqlRequest{
+		Sql: "INSERT INTO `group_join_request` (`groupid`, `uid`, `username`, `message`, `status`, `reason`) " +
+			"SELECT ?, ?, ?, ?, 'pending', '' FROM DUAL WHERE NOT EXISTS " +
+			"(SELECT 1 FROM `group_join_request` WHERE `groupid` = ? AND `uid` = ? AND `status` = 'pending')",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.Uid}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: username}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: req.Message}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.Uid}},
+		},
+		GetRowCount:     true,
+		GetLastInsertId: true,
+	}
+	insertResp, err := gateway.ExecSQL(insertReq)
+	if err != nil {
+		return &pb.RequestJoinResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Insert error: %v", err)},
+		}, nil
+	}
+	if insertResp.Result.Code != errorcode.Success {
+		return &pb.RequestJoinResponse{
+			Result: &pb.Result{Code: insertResp.Result.Code, Msg: insertResp.Result.Msg},
+		}, nil
+	}
+	if insertResp.RowsAffected == 0 {
+		return &pb.RequestJoinResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserRequestExists, Msg: "Request already exists"},
+		}, nil
+	}
+	return &pb.RequestJoinResponse{
+		Result:    &pb.Result{Code: errorcode.Success, Msg: ""},
+		RequestId: insertResp.LastInsertId,
+	}, nil
+}
+
+// ListJoinRequests 分页获取待处理的入群申请
+func (s *server) ListJoinRequests(ctx context.Context, req *pb.ListJoinRequestsRequest) (*pb.ListJoinRequestsResponse, error) {
+	limit := normalizeLimit(req.Limit)
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT " + joinRequestColumns + " FROM `group_join_request` " +
+			"WHERE `groupid` = ? AND `status` = 'pending' AND `id` > ? ORDER BY `id` LIMIT ?",
+		Db: pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: req.Cursor}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: limit}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return &pb.ListJoinRequestsResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return &pb.ListJoinRequestsResponse{
+			Result: &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg},
+		}, nil
+	}
+	requests := parseJoinRequests(sqlResp)
+	nextCursor := int64(0)
+	if len(requests) == int(limit) {
+		nextCursor = requests[len(requests)-1].Id
+	}
+	return &pb.ListJoinRequestsResponse{
+		Result:     &pb.Result{Code: errorcode.Success},
+		Requests:   requests,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+// ListMyJoinRequests 获取用户最近提交的入群申请及处理结果
+func (s *server) ListMyJoinRequests(ctx context.Context, req *pb.ListMyJoinRequestsRequest) (*pb.ListMyJoinRequestsResponse, error) {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT " + joinRequestColumns + " FROM `group_join_request` WHERE `uid` = ? ORDER BY `id` DESC LIMIT ?",
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.Uid}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: maxPageLimit}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return &pb.ListMyJoinRequestsResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return &pb.ListMyJoinRequestsResponse{
+			Result: &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg},
+		}, nil
+	}
+	return &pb.ListMyJoinRequestsResponse{
+		Result:   &pb.Result{Code: errorcode.Success},
+		Requests: parseJoinRequests(sqlResp),
+	}, nil
+}
+
+// resolveJoinRequest 将待处理申请标记为指定状态，返回申请内容
+func resolveJoinRequest(groupID int32, requestID int64, operatorUID int32, status string, reason string) (*pb.JoinRequestObject, *pb.Result) {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT " + joinRequestColumns + " FROM `group_join_request` WHERE `id` = ? AND `groupid` = ? AND `status` = 'pending'",
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: requestID}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return nil, &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)}
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return nil, &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg}
+	}
+	requests := parseJoinRequests(sqlResp)
+	if len(requests) == 0 {
+		return nil, &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Request not found"}
+	}
+
+	// 以状态条件更新，避免同一申请被重复处理
+	updateReq := &pb_gtw.SqlRequest{
+		Sql:    "UPDATE `group_join_request` SET `status` = ?, `reason` = ?, `operator_uid` = ? WHERE `id` = ? AND `status` = 'pending'",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Str{Str: status}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: reason}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: operatorUID}},
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: requestID}},
+		},
+		GetRowCount: true,
+	}
+	updateResp, err := gateway.ExecSQL(updateReq)
+	if err != nil {
+		return nil, &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Update error: %v", err)}
+	}
+	if updateResp.Result.Code != errorcode.Success {
+		return nil, &pb.Result{Code: updateResp.Result.Code, Msg: updateResp.Result.Msg}
+	}
+	if updateResp.RowsAffected == 0 {
+		return nil, &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Request not found"}
+	}
+	return requests[0], nil
+}
+
+// ApproveJoinRequest 通过入群申请
+func (s *server) ApproveJoinRequest(ctx context.Context, req *pb.ApproveJoinRequestRequest) (*pb.ApproveJoinRequestResponse, error) {
+	request, res := resolveJoinRequest(req.GroupId, req.RequestId, req.Uid, "approved", "")
+	if res != nil {
+		return &pb.ApproveJoinRequestResponse{Result: res}, nil
+	}
+	if res := insertGroupMember(req.GroupId, request.Username, request.Uid, req.Uid, pb.JoinMethod_approval); res.Code != errorcode.Success {
+		// 加入失败时恢复申请状态，以便重新处理
+		if res.Code != errorcode.GroupUserAlreadyInGroup {
+			revertResp, err := gateway.ExecSQL(&pb_gtw.SqlRequest{
+				Sql:    "UPDATE `group_join_request` SET `status` = 'pending' WHERE `id` = ?",
+				Db:     pb_gtw.SqlDatabases_Groups,
+				Commit: true,
+				Params: []*pb_gtw.InterFaceType{
+					{Response: &pb_gtw.InterFaceType_Int64{Int64: req.RequestId}},
+				},
+			})
+			if err != nil {
+				log.Printf("[GRPC]Revert join request error: %v\n", err)
+			} else if revertResp.Result.Code != errorcode.Success {
+				log.Printf("[GRPC]Revert join request error: [%d]%s\n", revertResp.Result.Code, revertResp.Result.Msg)
+			}
+		}
+		return &pb.ApproveJoinRequestResponse{Result: res}, nil
+	}
+	return &pb.ApproveJoinRequestResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
+
+// RejectJoinRequest 拒绝入群申请
+func (s *server) RejectJoinRequest(ctx context.Context, req *pb.RejectJoinRequestRequest) (*pb.RejectJoinRequestResponse, error) {
+	if utf8.RuneCountInString(req.Reason) > maxTextLength {
+		return &pb.RejectJoinRequestResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Reason too long"},
+		}, nil
+	}
+	if _, res := resolveJoinRequest(req.GroupId, req.RequestId, req.Uid, "rejected", req.Reason); res != nil {
+		return &pb.RejectJoinRequestResponse{Result: res}, nil
+	}
+	return &pb.RejectJoinRequestResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
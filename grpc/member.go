@@ -0,0 +1,194 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+	"StealthIMGroupUser/user"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// likeEscaper 转义 LIKE 模式中的通配符
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// queryMember 从数据库读取单个群成员，不在群内时返回 nil
+func queryMember(groupID int32, username string) (*pb.MemberObject, error) {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: memberSelect + "WHERE t1.`groupid` = ? AND t1.`username` = ?",
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: username}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return nil, err
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return nil, fmt.Errorf("[%d]%s", sqlResp.Result.Code, sqlResp.Result.Msg)
+	}
+	members := parseMembers(sqlResp)
+	if len(members) == 0 {
+		return nil, nil
+	}
+	return members[0], nil
+}
+
+// memberCacheKey 返回单个成员信息的缓存键
+func memberCacheKey(groupID int32, username string) string {
+	return "groupuser:member:" + fmt.Sprintf("%d:%s", groupID, username)
+}
+
+// delMemberCache 成员信息变化后清理对应的单成员缓存
+func delMemberCache(groupID int32, usernames ...string) {
+	for _, username := range usernames {
+		go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: memberCacheKey(groupID, username)})
+	}
+}
+
+// loadMemberCache 读取单成员缓存，未命中时回源数据库，不在群内时返回 nil
+func loadMemberCache(groupID int32, username string) (*pb.MemberObject, error) {
+	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: memberCacheKey(groupID, username)})
+	member := &pb.MemberObject{}
+	if err != nil || resp.Result.Code != errorcode.Success || len(resp.Value) == 0 || proto.Unmarshal(resp.Value, member) != nil {
+		// 非成员不写入缓存，加入群组时无需清理
+		member, err = queryMember(groupID, username)
+		if err != nil || member == nil {
+			return nil, err
+		}
+		cacheBytes, err := proto.Marshal(member)
+		if err == nil {
+			go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: memberCacheKey(groupID, username), Value: cacheBytes})
+		}
+	}
+	// 自定义角色可能已被修改或删除，以角色缓存为准
+	if member.RoleId != 0 {
+		roleObj, err := loadGroupRoleCache(groupID)
+		if err != nil {
+			return nil, err
+		}
+		if role := findRole(roleObj.Roles, member.RoleId); role != nil {
+			member.Role = role.Name
+			member.Rank = role.Rank
+		} else {
+			member.RoleId = 0
+			member.Role = convertProtoToSQLUserType(member.Type)
+			member.Rank = int32(memberRank(member.Type))
+		}
+	}
+	return member, nil
+}
+
+// checkMembership 查询用户在群组中的身份与禁言状态
+func checkMembership(ctx context.Context, groupID int32, uid int32) *pb.MembershipStatus {
+	status := &pb.MembershipStatus{GroupId: groupID, Uid: uid}
+	username, err := user.QueryUsernameByUID(ctx, uid)
+	if err != nil {
+		status.Result = &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)}
+		return status
+	}
+	member, err := loadMemberCache(groupID, username)
+	if err != nil {
+		status.Result = &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)}
+		return status
+	}
+	status.Result = &pb.Result{Code: errorcode.Success}
+	if member == nil {
+		return status
+	}
+	muteUntil, err := loadMuteUntil(groupID, uid)
+	if err != nil {
+		status.Result = &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)}
+		return status
+	}
+	if muteUntil <= time.Now().Unix() {
+		muteUntil = 0
+	}
+	status.IsMember = true
+	status.Member = member
+	status.IsMuted = muteUntil != 0
+	status.MutedUntil = muteUntil
+	return status
+}
+
+// CheckMembership 查询用户是否在群组中及其身份与限制，供其它服务使用
+func (s *server) CheckMembership(ctx context.Context, req *pb.CheckMembershipRequest) (*pb.CheckMembershipResponse, error) {
+	status := checkMembership(ctx, req.GroupId, req.Uid)
+	return &pb.CheckMembershipResponse{
+		Result: status.Result,
+		Status: status,
+	}, nil
+}
+
+// CheckMemberships 批量查询成员身份，每项单独返回结果
+func (s *server) CheckMemberships(ctx context.Context, req *pb.CheckMembershipsRequest) (*pb.CheckMembershipsResponse, error) {
+	if len(req.Keys) > int(maxPageLimit) {
+		return &pb.CheckMembershipsResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Too many keys"},
+		}, nil
+	}
+	statuses := make([]*pb.MembershipStatus, 0, len(req.Keys))
+	for _, key := range req.Keys {
+		statuses = append(statuses, checkMembership(ctx, key.GroupId, key.Uid))
+	}
+	return &pb.CheckMembershipsResponse{
+		Result:   &pb.Result{Code: errorcode.Success},
+		Statuses: statuses,
+	}, nil
+}
+
+// ListMembers 按用户名顺序分页获取群成员，不加载完整成员列表
+func (s *server) ListMembers(ctx context.Context, req *pb.ListMembersRequest) (*pb.ListMembersResponse, error) {
+	memberCount, res := countGroupMembers(req.GroupId)
+	if res != nil {
+		return &pb.ListMembersResponse{Result: res}, nil
+	}
+
+	limit := normalizeLimit(req.Limit)
+	sql := memberSelect + "WHERE t1.`groupid` = ? AND t1.`username` > ?"
+	params := []*pb_gtw.InterFaceType{
+		{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+		{Response: &pb_gtw.InterFaceType_Str{Str: req.Cursor}},
+	}
+	if req.RoleFilter != nil {
+		sql += " AND t1.`type` = ?"
+		params = append(params, &pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Str{Str: convertProtoToSQLUserType(req.GetRoleFilter())}})
+	}
+	if req.NamePrefix != "" {
+		sql += " AND t1.`username` LIKE ?"
+		params = append(params, &pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Str{Str: likeEscaper.Replace(req.NamePrefix) + "%"}})
+	}
+	sql += " ORDER BY t1.`username` LIMIT ?"
+	params = append(params, &pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Int32{Int32: limit}})
+
+	sqlResp, err := gateway.ExecSQL(&pb_gtw.SqlRequest{Sql: sql, Db: pb_gtw.SqlDatabases_Groups, Params: params})
+	if err != nil {
+		return &pb.ListMembersResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return &pb.ListMembersResponse{
+			Result: &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg},
+		}, nil
+	}
+	members := parseMembers(sqlResp)
+	nextCursor := ""
+	if len(members) == int(limit) {
+		nextCursor = members[len(members)-1].Name
+	}
+	return &pb.ListMembersResponse{
+		Result:      &pb.Result{Code: errorcode.Success},
+		Members:     members,
+		NextCursor:  nextCursor,
+		MemberCount: memberCount,
+	}, nil
+}
@@ -0,0 +1,202 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+	"StealthIMGroupUser/user"
+)
+
+// muteCacheKey 返回成员禁言截止时间的缓存键
+func muteCacheKey(groupID int32, uid int32) string {
+	return "groupuser:mute:" + fmt.Sprintf("%d:%d", groupID, uid)
+}
+
+// muteCacheIdleTTL 未禁言状态的缓存秒数
+const muteCacheIdleTTL = 300
+
+// storeMuteUntil 禁言或解除禁言写入数据库后同步更新缓存，禁言中缓存至截止时间，写入失败时删除缓存
+// 只由写入方调用，读取未命中时不回填，避免并发读取以旧值覆盖刚写入的禁言
+func storeMuteUntil(groupID int32, uid int32, muteUntil int64) {
+	ttl := int64(muteCacheIdleTTL)
+	if remain := muteUntil - time.Now().Unix(); remain > 0 {
+		ttl = min(remain, muteCacheIdleTTL)
+	}
+	resp, err := gateway.ExecRedisSet(&pb_gtw.RedisSetStringRequest{DBID: 0, Key: muteCacheKey(groupID, uid), Value: strconv.FormatInt(muteUntil, 10), Ttl: int32(ttl)})
+	if err != nil || resp.Result.Code != errorcode.Success {
+		gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: muteCacheKey(groupID, uid)})
+	}
+}
+
+// loadMuteUntil 读取成员禁言截止时间，未禁言时返回 0
+func loadMuteUntil(groupID int32, uid int32) (int64, error) {
+	resp, err := gateway.ExecRedisGet(&pb_gtw.RedisGetStringRequest{DBID: 0, Key: muteCacheKey(groupID, uid)})
+	if err == nil && resp.Result.Code == errorcode.Success && len(resp.Value) > 0 {
+		if muteUntil, err := strconv.ParseInt(resp.Value, 10, 64); err == nil {
+			return muteUntil, nil
+		}
+	}
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT `expire_time` FROM `group_mute` WHERE `groupid` = ? AND `uid` = ?",
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: uid}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return 0, err
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return 0, fmt.Errorf("[%d]%s", sqlResp.Result.Code, sqlResp.Result.Msg)
+	}
+	muteUntil := int64(0)
+	if len(sqlResp.Data) > 0 && len(sqlResp.Data[0].Result) > 0 {
+		muteUntil = sqlResp.Data[0].Result[0].GetInt64()
+	}
+	return muteUntil, nil
+}
+
+// clearMute 成员离开群组后删除其禁言记录与缓存，成员变化已提交，失败只记录日志
+func clearMute(groupID int32, uid int32) {
+	deleteReq := &pb_gtw.SqlRequest{
+		Sql:    "DELETE FROM `group_mute` WHERE `groupid` = ? AND `uid` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: uid}},
+		},
+	}
+	deleteResp, err := gateway.ExecSQL(deleteReq)
+	if err != nil {
+		log.Printf("[GRPC]Clear mute error: %v\n", err)
+	} else if deleteResp.Result.Code != errorcode.Success {
+		log.Printf("[GRPC]Clear mute error: [%d]%s\n", deleteResp.Result.Code, deleteResp.Result.Msg)
+	}
+	gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: muteCacheKey(groupID, uid)})
+}
+
+// checkRestrictTarget 按踢人的身份规则检查操作者能否限制目标成员，踢人权限已由授权拦截器检查
+func checkRestrictTarget(self *pb.MemberObject, members []*pb.MemberObject, username string) *pb.Result {
+	target := findMember(members, username)
+	if target == nil {
+		return &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "User not found"}
+	}
+	if target.Type == pb.MemberType_owner || self.Name == username || !outranks(self, target) {
+		return &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"}
+	}
+	return nil
+}
+
+// MuteMember 禁言群成员至指定时间
+func (s *server) MuteMember(ctx context.Context, req *pb.MuteMemberRequest) (*pb.MuteMemberResponse, error) {
+	if req.Until <= time.Now().Unix() {
+		return &pb.MuteMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Invalid mute time"},
+		}, nil
+	}
+	self, members := actorOf(ctx)
+	if res := checkRestrictTarget(self, members, req.Username); res != nil {
+		return &pb.MuteMemberResponse{Result: res}, nil
+	}
+	targetUID, err := user.QueryUIDByUsername(ctx, req.Username)
+	if err != nil {
+		return &pb.MuteMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+		}, nil
+	}
+
+	insertReq := &pb_gtw.SqlRequest{
+		Sql: "INSERT INTO `group_mute` (`groupid`, `uid`, `operator_uid`, `expire_time`) VALUES (?, ?, ?, ?) " +
+			"ON DUPLICATE KEY UPDATE `operator_uid` = VALUES(`operator_uid`), `expire_time` = VALUES(`expire_time`)",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: targetUID}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.Uid}},
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: req.Until}},
+		},
+	}
+	insertResp, err := gateway.ExecSQL(insertReq)
+	if err != nil {
+		return &pb.MuteMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Insert error: %v", err)},
+		}, nil
+	}
+	if insertResp.Result.Code != errorcode.Success {
+		return &pb.MuteMemberResponse{
+			Result: &pb.Result{Code: insertResp.Result.Code, Msg: insertResp.Result.Msg},
+		}, nil
+	}
+	storeMuteUntil(req.GroupId, targetUID, req.Until)
+	return &pb.MuteMemberResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
+
+// UnmuteMember 解除群成员禁言
+func (s *server) UnmuteMember(ctx context.Context, req *pb.UnmuteMemberRequest) (*pb.UnmuteMemberResponse, error) {
+	self, members := actorOf(ctx)
+	if res := checkRestrictTarget(self, members, req.Username); res != nil {
+		return &pb.UnmuteMemberResponse{Result: res}, nil
+	}
+	targetUID, err := user.QueryUIDByUsername(ctx, req.Username)
+	if err != nil {
+		return &pb.UnmuteMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+		}, nil
+	}
+
+	deleteReq := &pb_gtw.SqlRequest{
+		Sql:    "DELETE FROM `group_mute` WHERE `groupid` = ? AND `uid` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: targetUID}},
+		},
+	}
+	deleteResp, err := gateway.ExecSQL(deleteReq)
+	if err != nil {
+		return &pb.UnmuteMemberResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Delete error: %v", err)},
+		}, nil
+	}
+	if deleteResp.Result.Code != errorcode.Success {
+		return &pb.UnmuteMemberResponse{
+			Result: &pb.Result{Code: deleteResp.Result.Code, Msg: deleteResp.Result.Msg},
+		}, nil
+	}
+	storeMuteUntil(req.GroupId, targetUID, 0)
+	return &pb.UnmuteMemberResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
+
+// GetMemberRestrictions 查询成员在群组中的禁言状态
+func (s *server) GetMemberRestrictions(ctx context.Context, req *pb.GetMemberRestrictionsRequest) (*pb.GetMemberRestrictionsResponse, error) {
+	muteUntil, err := loadMuteUntil(req.GroupId, req.Uid)
+	if err != nil {
+		return &pb.GetMemberRestrictionsResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	if muteUntil <= time.Now().Unix() {
+		muteUntil = 0
+	}
+	return &pb.GetMemberRestrictionsResponse{
+		Result:     &pb.Result{Code: errorcode.Success},
+		IsMuted:    muteUntil != 0,
+		MutedUntil: muteUntil,
+	}, nil
+}
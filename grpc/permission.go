@@ -0,0 +1,213 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// allActions 群组中可配置的全部操作
+var allActions = []pb.GroupAction{
+	pb.GroupAction_invite,
+	pb.GroupAction_kick,
+	pb.GroupAction_rename,
+	pb.GroupAction_change_password,
+	pb.GroupAction_change_roles,
+	pb.GroupAction_view_members,
+}
+
+// permissionTypes 权限表中列出的身份，其中群主始终拥有全部权限
+var permissionTypes = []pb.MemberType{
+	pb.MemberType_other,
+	pb.MemberType_member,
+	pb.MemberType_manager,
+	pb.MemberType_owner,
+}
+
+// actionMask 将操作列表转为位掩码
+func actionMask(actions []pb.GroupAction) int32 {
+	mask := int32(0)
+	for _, action := range actions {
+		mask |= 1 << int32(action)
+	}
+	return mask
+}
+
+// maskActions 将位掩码转为操作列表
+func maskActions(mask int32) []pb.GroupAction {
+	var actions []pb.GroupAction
+	for _, action := range allActions {
+		if mask&(1<<int32(action)) != 0 {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+// defaultActionMask 返回未配置时各身份的默认权限
+func defaultActionMask(memberType pb.MemberType) int32 {
+	switch memberType {
+	case pb.MemberType_owner, pb.MemberType_manager:
+		return actionMask(allActions)
+	case pb.MemberType_member:
+		return actionMask([]pb.GroupAction{pb.GroupAction_invite, pb.GroupAction_view_members})
+	default:
+		return actionMask([]pb.GroupAction{pb.GroupAction_view_members})
+	}
+}
+
+// loadGroupPermissionCache 读取群组权限表缓存，未命中时回源数据库并补全默认值
+func loadGroupPermissionCache(groupID int32) (*pb.GroupPermissionCache, error) {
+	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:permission:" + fmt.Sprintf("%d", groupID)})
+	cacheObj := &pb.GroupPermissionCache{}
+	if err == nil && resp.Result.Code == errorcode.Success && len(resp.Value) > 0 && proto.Unmarshal(resp.Value, cacheObj) == nil {
+		return cacheObj, nil
+	}
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT CAST(`type` AS CHAR), `actions` FROM `group_permission` WHERE `groupid` = ?",
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return nil, err
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return nil, fmt.Errorf("[%d]%s", sqlResp.Result.Code, sqlResp.Result.Msg)
+	}
+	masks := map[pb.MemberType]int32{}
+	for _, row := range sqlResp.Data {
+		if len(row.Result) < 2 {
+			continue
+		}
+		masks[convertSQLUserTypeToProto(row.Result[0].GetStr())] = row.Result[1].GetInt32()
+	}
+	cacheObj = &pb.GroupPermissionCache{}
+	for _, memberType := range permissionTypes {
+		mask, ok := masks[memberType]
+		if !ok || memberType == pb.MemberType_owner {
+			mask = defaultActionMask(memberType)
+		}
+		cacheObj.Permissions = append(cacheObj.Permissions, &pb.RolePermission{Type: memberType, Actions: maskActions(mask)})
+	}
+	cacheBytes, err := proto.Marshal(cacheObj)
+	if err == nil {
+		go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: "groupuser:permission:" + fmt.Sprintf("%d", groupID), Value: cacheBytes})
+	}
+	return cacheObj, nil
+}
+
+// hasPermission 判断身份在群组中是否拥有指定操作权限
+func hasPermission(cacheObj *pb.GroupPermissionCache, memberType pb.MemberType, action pb.GroupAction) bool {
+	if memberType == pb.MemberType_owner {
+		return true
+	}
+	for _, element := range cacheObj.Permissions {
+		if element.Type == memberType {
+			return actionMask(element.Actions)&(1<<int32(action)) != 0
+		}
+	}
+	return false
+}
+
+// checkPermission 检查成员在群组中是否拥有指定操作权限，持有自定义角色时以角色权限为准
+func checkPermission(groupID int32, member *pb.MemberObject, action pb.GroupAction) *pb.Result {
+	if member.RoleId != 0 && member.Type != pb.MemberType_owner {
+		roleObj, err := loadGroupRoleCache(groupID)
+		if err != nil {
+			return &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)}
+		}
+		if role := findRole(roleObj.Roles, member.RoleId); role != nil {
+			if actionMask(role.Actions)&(1<<int32(action)) == 0 {
+				return &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"}
+			}
+			return nil
+		}
+	}
+	permissionObj, err := loadGroupPermissionCache(groupID)
+	if err != nil {
+		return &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)}
+	}
+	if !hasPermission(permissionObj, member.Type, action) {
+		return &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"}
+	}
+	return nil
+}
+
+// GetGroupPermissions 获取群组各身份的权限
+func (s *server) GetGroupPermissions(ctx context.Context, req *pb.GetGroupPermissionsRequest) (*pb.GetGroupPermissionsResponse, error) {
+	permissionObj, err := loadGroupPermissionCache(req.GroupId)
+	if err != nil {
+		return &pb.GetGroupPermissionsResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	return &pb.GetGroupPermissionsResponse{
+		Result:      &pb.Result{Code: errorcode.Success},
+		Permissions: permissionObj.Permissions,
+	}, nil
+}
+
+// SetGroupPermissions 设置群组各身份的权限，仅群主可用
+func (s *server) SetGroupPermissions(ctx context.Context, req *pb.SetGroupPermissionsRequest) (*pb.SetGroupPermissionsResponse, error) {
+	var placeholders []string
+	var params []*pb_gtw.InterFaceType
+	for _, element := range req.Permissions {
+		// 群主权限不可配置，避免群主被锁在群外
+		if element.Type == pb.MemberType_owner {
+			return &pb.SetGroupPermissionsResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Owner permissions are fixed"},
+			}, nil
+		}
+		for _, action := range element.Actions {
+			if _, ok := pb.GroupAction_name[int32(action)]; !ok {
+				return &pb.SetGroupPermissionsResponse{
+					Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Invalid action"},
+				}, nil
+			}
+		}
+		placeholders = append(placeholders, "(?, ?, ?)")
+		params = append(params,
+			&pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			&pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Str{Str: convertProtoToSQLUserType(element.Type)}},
+			&pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Int32{Int32: actionMask(element.Actions)}},
+		)
+	}
+	if len(placeholders) == 0 {
+		return &pb.SetGroupPermissionsResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "No permissions given"},
+		}, nil
+	}
+
+	insertReq := &pb_gtw.SqlRequest{
+		Sql: "INSERT INTO `group_permission` (`groupid`, `type`, `actions`) VALUES " + strings.Join(placeholders, ", ") +
+			" ON DUPLICATE KEY UPDATE `actions` = VALUES(`actions`)",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: params,
+	}
+	insertResp, err := gateway.ExecSQL(insertReq)
+	if err != nil {
+		return &pb.SetGroupPermissionsResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Insert error: %v", err)},
+		}, nil
+	}
+	if insertResp.Result.Code != errorcode.Success {
+		return &pb.SetGroupPermissionsResponse{
+			Result: &pb.Result{Code: insertResp.Result.Code, Msg: insertResp.Result.Msg},
+		}, nil
+	}
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:permission:" + fmt.Sprintf("%d", req.GroupId)})
+	return &pb.SetGroupPermissionsResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
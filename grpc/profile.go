@@ -0,0 +1,86 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+)
+
+// SetMemberProfile 设置群昵称与头衔，未指定用户名时修改自己的资料
+func (s *server) SetMemberProfile(ctx context.Context, req *pb.SetMemberProfileRequest) (*pb.SetMemberProfileResponse, error) {
+	if req.Nickname == nil && req.Title == nil {
+		return &pb.SetMemberProfileResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Nothing to update"},
+		}, nil
+	}
+	if utf8.RuneCountInString(req.GetNickname()) > maxNicknameLength || utf8.RuneCountInString(req.GetTitle()) > maxNicknameLength {
+		return &pb.SetMemberProfileResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Nickname or title too long"},
+		}, nil
+	}
+	self, members := actorOf(ctx)
+	target := self
+	if req.Username != "" && req.Username != self.Name {
+		target = findMember(members, req.Username)
+		if target == nil {
+			return &pb.SetMemberProfileResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "User not found"},
+			}, nil
+		}
+		// 修改他人资料需要管理员以上身份，且只能修改低于自己的成员
+		if !outranksType(self, pb.MemberType_member) || !outranks(self, target) {
+			return &pb.SetMemberProfileResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
+			}, nil
+		}
+	}
+	// 头衔仅管理员以上可以设置
+	if req.Title != nil && !outranksType(self, pb.MemberType_member) {
+		return &pb.SetMemberProfileResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
+		}, nil
+	}
+
+	var sets []string
+	var params []*pb_gtw.InterFaceType
+	if req.Nickname != nil {
+		sets = append(sets, "`nickname` = ?")
+		params = append(params, &pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Str{Str: req.GetNickname()}})
+	}
+	if req.Title != nil {
+		sets = append(sets, "`title` = ?")
+		params = append(params, &pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Str{Str: req.GetTitle()}})
+	}
+	params = append(params,
+		&pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+		&pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Str{Str: target.Name}},
+	)
+	updateReq := &pb_gtw.SqlRequest{
+		Sql:    "UPDATE `group_user_table` SET " + strings.Join(sets, ", ") + " WHERE `groupid` = ? AND `username` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: params,
+	}
+	updateResp, err := gateway.ExecSQL(updateReq)
+	if err != nil {
+		return &pb.SetMemberProfileResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Update error: %v", err)},
+		}, nil
+	}
+	if updateResp.Result.Code != errorcode.Success {
+		return &pb.SetMemberProfileResponse{
+			Result: &pb.Result{Code: updateResp.Result.Code, Msg: updateResp.Result.Msg},
+		}, nil
+	}
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
+	delMemberCache(req.GroupId, target.Name)
+	return &pb.SetMemberProfileResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
@@ -0,0 +1,371 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/event"
+	"StealthIMGroupUser/gateway"
+	"StealthIMGroupUser/outbox"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// maxCustomRoles 单个群组最多可定义的自定义角色数
+	maxCustomRoles = 50
+	// maxRoleNameLength 自定义角色名的最大长度
+	maxRoleNameLength = 32
+)
+
+// memberRank 返回内置身份的等级，数值越大权限越高，等级之间留给自定义角色
+func memberRank(memberType pb.MemberType) int {
+	switch memberType {
+	case pb.MemberType_owner:
+		return 300
+	case pb.MemberType_manager:
+		return 200
+	case pb.MemberType_member:
+		return 100
+	default:
+		return 0
+	}
+}
+
+// rankOf 返回成员的有效等级，持有自定义角色时使用角色等级，群主始终最高
+func rankOf(member *pb.MemberObject) int {
+	if member.RoleId == 0 || member.Type == pb.MemberType_owner {
+		return memberRank(member.Type)
+	}
+	return int(member.Rank)
+}
+
+// outranks 判断 actor 的等级是否严格高于 target
+func outranks(actor *pb.MemberObject, target *pb.MemberObject) bool {
+	return rankOf(actor) > rankOf(target)
+}
+
+// outranksType 判断 actor 的等级是否严格高于内置身份 target
+func outranksType(actor *pb.MemberObject, target pb.MemberType) bool {
+	return rankOf(actor) > memberRank(target)
+}
+
+// loadGroupRoleCache 读取群组自定义角色缓存，未命中时回源数据库
+func loadGroupRoleCache(groupID int32) (*pb.GroupRoleCache, error) {
+	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:roles:" + fmt.Sprintf("%d", groupID)})
+	cacheObj := &pb.GroupRoleCache{}
+	if err == nil && resp.Result.Code == errorcode.Success && len(resp.Value) > 0 && proto.Unmarshal(resp.Value, cacheObj) == nil {
+		return cacheObj, nil
+	}
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT `id`, `name`, `rank`, `actions` FROM `group_role` WHERE `groupid` = ? ORDER BY `rank` DESC, `id`",
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return nil, err
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return nil, fmt.Errorf("[%d]%s", sqlResp.Result.Code, sqlResp.Result.Msg)
+	}
+	cacheObj = &pb.GroupRoleCache{}
+	for _, row := range sqlResp.Data {
+		if len(row.Result) < 4 {
+			continue
+		}
+		cacheObj.Roles = append(cacheObj.Roles, &pb.RoleObject{
+			Id:      row.Result[0].GetInt64(),
+			Name:    row.Result[1].GetStr(),
+			Rank:    row.Result[2].GetInt32(),
+			Actions: maskActions(row.Result[3].GetInt32()),
+		})
+	}
+	cacheBytes, err := proto.Marshal(cacheObj)
+	if err == nil {
+		go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: "groupuser:roles:" + fmt.Sprintf("%d", groupID), Value: cacheBytes})
+	}
+	return cacheObj, nil
+}
+
+// findRole 在自定义角色列表中查找角色
+func findRole(roles []*pb.RoleObject, roleID int64) *pb.RoleObject {
+	for _, element := range roles {
+		if element.Id == roleID {
+			return element
+		}
+	}
+	return nil
+}
+
+// checkRoleArgs 检查自定义角色的名称、等级与权限
+func checkRoleArgs(name string, rank int32, actions []pb.GroupAction) *pb.Result {
+	if name == "" || utf8.RuneCountInString(name) > maxRoleNameLength {
+		return &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Invalid role name"}
+	}
+	// 自定义角色必须低于群主，避免与群主平级
+	if rank <= 0 || int(rank) >= memberRank(pb.MemberType_owner) {
+		return &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Invalid role rank"}
+	}
+	for _, action := range actions {
+		if _, ok := pb.GroupAction_name[int32(action)]; !ok {
+			return &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Invalid action"}
+		}
+	}
+	return nil
+}
+
+// delRoleCaches 角色变化后清理角色与成员缓存
+func delRoleCaches(groupID int32) {
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:roles:" + fmt.Sprintf("%d", groupID)})
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", groupID)})
+}
+
+// ListRoles 获取群组的内置角色与自定义角色
+func (s *server) ListRoles(ctx context.Context, req *pb.ListRolesRequest) (*pb.ListRolesResponse, error) {
+	permissionObj, err := loadGroupPermissionCache(req.GroupId)
+	if err != nil {
+		return &pb.ListRolesResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	roleObj, err := loadGroupRoleCache(req.GroupId)
+	if err != nil {
+		return &pb.ListRolesResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	var roles []*pb.RoleObject
+	for _, element := range permissionObj.Permissions {
+		roles = append(roles, &pb.RoleObject{
+			Name:        convertProtoToSQLUserType(element.Type),
+			Rank:        int32(memberRank(element.Type)),
+			Actions:     element.Actions,
+			IsBuiltin:   true,
+			BuiltinType: element.Type,
+		})
+	}
+	roles = append(roles, roleObj.Roles...)
+	return &pb.ListRolesResponse{
+		Result: &pb.Result{Code: errorcode.Success},
+		Roles:  roles,
+	}, nil
+}
+
+// CreateRole 创建自定义角色，仅群主可用
+func (s *server) CreateRole(ctx context.Context, req *pb.CreateRoleRequest) (*pb.CreateRoleResponse, error) {
+	if res := checkRoleArgs(req.Name, req.Rank, req.Actions); res != nil {
+		return &pb.CreateRoleResponse{Result: res}, nil
+	}
+	// 角色数量达到上限或重名时拒绝创建
+	insertReq := &pb_gtw.SqlRequest{
+		Sql: "INSERT INTO `group_role` (`groupid`, `name`, `rank`, `actions`) SELECT ?, ?, ?, ? FROM DUAL WHERE " +
+			"NOT EXISTS (SELECT 1 FROM `group_role` WHERE `groupid` = ? AND `name` = ?) " +
+			"AND (SELECT COUNT(*) FROM `group_role` WHERE `groupid` = ?) < ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: req.Name}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.Rank}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: actionMask(req.Actions)}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: req.Name}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: maxCustomRoles}},
+		},
+		GetRowCount:     true,
+		GetLastInsertId: true,
+	}
+	insertResp, err := gateway.ExecSQL(insertReq)
+	if err != nil {
+		return &pb.CreateRoleResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Insert error: %v", err)},
+		}, nil
+	}
+	if insertResp.Result.Code != errorcode.Success {
+		return &pb.CreateRoleResponse{
+			Result: &pb.Result{Code: insertResp.Result.Code, Msg: insertResp.Result.Msg},
+		}, nil
+	}
+	if insertResp.RowsAffected == 0 {
+		return &pb.CreateRoleResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Role exists or too many roles"},
+		}, nil
+	}
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:roles:" + fmt.Sprintf("%d", req.GroupId)})
+	return &pb.CreateRoleResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+		RoleId: insertResp.LastInsertId,
+	}, nil
+}
+
+// UpdateRole 修改自定义角色，仅群主可用
+func (s *server) UpdateRole(ctx context.Context, req *pb.UpdateRoleRequest) (*pb.UpdateRoleResponse, error) {
+	if res := checkRoleArgs(req.Name, req.Rank, req.Actions); res != nil {
+		return &pb.UpdateRoleResponse{Result: res}, nil
+	}
+	updateReq := &pb_gtw.SqlRequest{
+		Sql:    "UPDATE `group_role` SET `name` = ?, `rank` = ?, `actions` = ? WHERE `id` = ? AND `groupid` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Str{Str: req.Name}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.Rank}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: actionMask(req.Actions)}},
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: req.RoleId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+		},
+		GetRowCount: true,
+	}
+	updateResp, err := gateway.ExecSQL(updateReq)
+	if err != nil {
+		return &pb.UpdateRoleResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Update error: %v", err)},
+		}, nil
+	}
+	if updateResp.Result.Code != errorcode.Success {
+		return &pb.UpdateRoleResponse{
+			Result: &pb.Result{Code: updateResp.Result.Code, Msg: updateResp.Result.Msg},
+		}, nil
+	}
+	if updateResp.RowsAffected == 0 {
+		return &pb.UpdateRoleResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Role not found"},
+		}, nil
+	}
+	delRoleCaches(req.GroupId)
+	return &pb.UpdateRoleResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
+
+// DeleteRole 删除自定义角色，持有该角色的成员恢复为内置身份
+func (s *server) DeleteRole(ctx context.Context, req *pb.DeleteRoleRequest) (*pb.DeleteRoleResponse, error) {
+	deleteReq := &pb_gtw.SqlRequest{
+		Sql:    "DELETE FROM `group_role` WHERE `id` = ? AND `groupid` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: req.RoleId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+		},
+		GetRowCount: true,
+	}
+	deleteResp, err := gateway.ExecSQL(deleteReq)
+	if err != nil {
+		return &pb.DeleteRoleResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Delete error: %v", err)},
+		}, nil
+	}
+	if deleteResp.Result.Code != errorcode.Success {
+		return &pb.DeleteRoleResponse{
+			Result: &pb.Result{Code: deleteResp.Result.Code, Msg: deleteResp.Result.Msg},
+		}, nil
+	}
+	if deleteResp.RowsAffected == 0 {
+		return &pb.DeleteRoleResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Role not found"},
+		}, nil
+	}
+	// 成员表中残留的角色编号在查询时按内置身份处理，清理失败时如实返回错误
+	resetResp, err := gateway.ExecSQL(&pb_gtw.SqlRequest{
+		Sql:    "UPDATE `group_user_table` SET `role_id` = 0 WHERE `groupid` = ? AND `role_id` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: req.RoleId}},
+		},
+	})
+	// 角色已删除，无论清理是否成功都需使缓存失效
+	delRoleCaches(req.GroupId)
+	if err != nil {
+		return &pb.DeleteRoleResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Update error: %v", err)},
+		}, nil
+	}
+	if resetResp.Result.Code != errorcode.Success {
+		return &pb.DeleteRoleResponse{
+			Result: &pb.Result{Code: resetResp.Result.Code, Msg: resetResp.Result.Msg},
+		}, nil
+	}
+	return &pb.DeleteRoleResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
+
+// AssignRole 为成员分配自定义角色，角色编号为 0 时恢复为内置身份
+func (s *server) AssignRole(ctx context.Context, req *pb.AssignRoleRequest) (*pb.AssignRoleResponse, error) {
+	self, members := actorOf(ctx)
+	target := findMember(members, req.Username)
+	if target == nil {
+		return &pb.AssignRoleResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "User not found"},
+		}, nil
+	}
+	// 与调整身份相同，只能调整低于自己的成员
+	if target.Type == pb.MemberType_owner || !outranks(self, target) {
+		return &pb.AssignRoleResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
+		}, nil
+	}
+	newRole := convertProtoToSQLUserType(target.Type)
+	if req.RoleId != 0 {
+		roleObj, err := loadGroupRoleCache(req.GroupId)
+		if err != nil {
+			return &pb.AssignRoleResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+			}, nil
+		}
+		role := findRole(roleObj.Roles, req.RoleId)
+		if role == nil {
+			return &pb.AssignRoleResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Role not found"},
+			}, nil
+		}
+		// 只能授予低于自己的角色
+		if rankOf(self) <= int(role.Rank) {
+			return &pb.AssignRoleResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
+			}, nil
+		}
+		newRole = role.Name
+	}
+
+	updateReq := &pb_gtw.SqlRequest{
+		Sql:    "UPDATE `group_user_table` SET `role_id` = ? WHERE `groupid` = ? AND `username` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: req.RoleId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: req.Username}},
+		},
+	}
+	ev := newGroupEvent(req.GroupId, pb.GroupEventType_role_changed, req.Uid, req.Username, target.Role, newRole)
+	outbox.Attach(updateReq, ev)
+	updateResp, err := gateway.ExecSQL(updateReq)
+	if err != nil {
+		return &pb.AssignRoleResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Update error: %v", err)},
+		}, nil
+	}
+	if updateResp.Result.Code != errorcode.Success {
+		return &pb.AssignRoleResponse{
+			Result: &pb.Result{Code: updateResp.Result.Code, Msg: updateResp.Result.Msg},
+		}, nil
+	}
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
+	delMemberCache(req.GroupId, req.Username)
+	event.Publish(ev)
+	return &pb.AssignRoleResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
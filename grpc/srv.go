@@ -4,71 +4,27 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
+	"log"
 
 	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
 	pb "StealthIMGroupUser/StealthIM.GroupUser"
 	"StealthIMGroupUser/config"
 	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/event"
 	"StealthIMGroupUser/gateway"
+	"StealthIMGroupUser/outbox"
 	"StealthIMGroupUser/user"
 	"crypto/sha256"
-
-	"google.golang.org/protobuf/proto"
 )
 
 // GetGroupsByUID 获取用户加入的群组列表
 func (s *server) GetGroupsByUID(ctx context.Context, req *pb.GetGroupsByUIDRequest) (*pb.GetGroupsByUIDResponse, error) {
-
-	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:groups:" + fmt.Sprintf("%d", req.Uid)})
-
-	cacheObj := &pb.GetGroupsByUIDCache{}
-
-	if err != nil || resp.Result.Code != errorcode.Success || len(resp.Value) == 0 || (proto.Unmarshal(resp.Value, cacheObj) != nil) {
-		username, err := user.QueryUsernameByUID(ctx, req.Uid)
-		if err != nil {
-			return &pb.GetGroupsByUIDResponse{
-				Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
-			}, nil
-		}
-		// 查询group_user_table获取用户群组
-		sqlReq := &pb_gtw.SqlRequest{
-			Sql: "SELECT groupid FROM `group_user_table` WHERE username = ?",
-			Db:  pb_gtw.SqlDatabases_Groups,
-			Params: []*pb_gtw.InterFaceType{
-				{Response: &pb_gtw.InterFaceType_Str{Str: username}},
-			},
-		}
-
-		sqlResp, err := gateway.ExecSQL(sqlReq)
-		if err != nil {
-			return &pb.GetGroupsByUIDResponse{
-				Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
-			}, nil
-		}
-		if sqlResp.Result.Code != errorcode.Success {
-			return &pb.GetGroupsByUIDResponse{
-				Result: &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg},
-			}, nil
-		}
-
-		// 解析群组ID列表
-		var groups []int32
-		for _, row := range sqlResp.Data {
-			if len(row.Result) > 0 {
-				groupID, ok := row.Result[0].Response.(*pb_gtw.InterFaceType_Int32)
-				if ok {
-					groups = append(groups, groupID.Int32)
-				}
-			}
-		}
-
-		cacheObj.Groups = groups
-
-		// 将结果缓存到Redis
-		cacheBytes, err := proto.Marshal(cacheObj)
-		if err == nil {
-			go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: "groupuser:groups:" + fmt.Sprintf("%d", req.Uid), Value: cacheBytes})
-		}
+	cacheObj, res := loadUserGroups(ctx, req.Uid)
+	if res != nil {
+		return &pb.GetGroupsByUIDResponse{Result: res}, nil
+	}
+	if req.Detailed {
+		return listGroupSummaries(ctx, req, cacheObj), nil
 	}
 	return &pb.GetGroupsByUIDResponse{
 		Result: &pb.Result{Code: errorcode.Success},
@@ -78,41 +34,11 @@ func (s *server) GetGroupsByUID(ctx context.Context, req *pb.GetGroupsByUIDReque
 
 // GetGroupPublicInfo 获取群组公开信息
 func (s *server) GetGroupPublicInfo(ctx context.Context, req *pb.GetGroupPublicInfoRequest) (*pb.GetGroupPublicInfoResponse, error) {
-	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:public:" + fmt.Sprintf("%d", req.GroupId)})
-	cacheObj := &pb.GetGroupPublicInfoCache{}
-	if err != nil || resp.Result.Code != errorcode.Success || len(resp.Value) == 0 || (proto.Unmarshal(resp.Value, cacheObj) != nil) {
-		for { // 利用控制流跳出
-			sqlReq := &pb_gtw.SqlRequest{
-				Sql: "SELECT `name`, `create_time` FROM `groups` WHERE groupid = ?",
-				Db:  pb_gtw.SqlDatabases_Groups,
-				Params: []*pb_gtw.InterFaceType{
-					{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
-				},
-			}
-
-			sqlResp, err := gateway.ExecSQL(sqlReq)
-			if err != nil {
-				return &pb.GetGroupPublicInfoResponse{
-					Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
-				}, nil
-			}
-			if sqlResp.Result.Code != errorcode.Success || len(sqlResp.Data) == 0 {
-				cacheObj.Id = -1
-				break
-			}
-
-			row := sqlResp.Data[0]
-			cacheObj.Id = req.GroupId
-			cacheObj.Name = row.Result[0].GetStr()
-			cacheObj.CreatedAt = row.Result[1].GetInt64()
-			if true { // 保证始终跳出
-				break
-			}
-		}
-		cacheBytes, err := proto.Marshal(cacheObj)
-		if err == nil {
-			go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: "groupuser:public:" + fmt.Sprintf("%d", req.GroupId), Value: cacheBytes})
-		}
+	cacheObj, err := loadGroupPublicCache(req.GroupId)
+	if err != nil {
+		return &pb.GetGroupPublicInfoResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
 	}
 	if cacheObj.Id == -1 {
 		return &pb.GetGroupPublicInfoResponse{
@@ -120,10 +46,12 @@ func (s *server) GetGroupPublicInfo(ctx context.Context, req *pb.GetGroupPublicI
 		}, nil
 	}
 	return &pb.GetGroupPublicInfoResponse{
-		Result:    &pb.Result{Code: errorcode.Success},
-		Id:        cacheObj.Id,
-		Name:      cacheObj.Name,
-		CreatedAt: cacheObj.CreatedAt,
+		Result:      &pb.Result{Code: errorcode.Success},
+		Id:          cacheObj.Id,
+		Name:        cacheObj.Name,
+		CreatedAt:   cacheObj.CreatedAt,
+		JoinPolicy:  cacheObj.JoinPolicy,
+		MemberCount: cacheObj.MemberCount,
 	}, nil
 }
 
@@ -156,124 +84,122 @@ func convertProtoToSQLUserType(protoType pb.MemberType) string {
 	}
 }
 
-// GetGroupInfo 获取群组信息
-func (s *server) GetGroupInfo(ctx context.Context, req *pb.GetGroupInfoRequest) (*pb.GetGroupInfoResponse, error) {
-	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
-	cacheObj := &pb.GetGroupInfoCache{}
-	if err != nil || resp.Result.Code != errorcode.Success || len(resp.Value) == 0 || (proto.Unmarshal(resp.Value, cacheObj) != nil) {
-		for { // 利用控制流跳出
-			sqlReq := &pb_gtw.SqlRequest{
-				Sql: "SELECT `username`, CAST(`type` AS CHAR) FROM `group_user_table` WHERE groupid = ?",
-				Db:  pb_gtw.SqlDatabases_Groups,
-				Params: []*pb_gtw.InterFaceType{
-					{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
-				},
-			}
-
-			sqlResp, err := gateway.ExecSQL(sqlReq)
-			if err != nil {
-				return &pb.GetGroupInfoResponse{
-					Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
-				}, nil
-			}
-			if sqlResp.Result.Code != errorcode.Success || len(sqlResp.Data) == 0 {
-				break
-			}
-
-			for _, row := range sqlResp.Data {
-				cacheObj.Members = append(cacheObj.Members, &pb.MemberObject{
-					Name: row.Result[0].GetStr(),
-					Type: convertSQLUserTypeToProto(row.Result[1].GetStr()),
-				})
-			}
-			if true { // 保证始终跳出
-				break
-			}
-		}
-		cacheBytes, err := proto.Marshal(cacheObj)
-		if err == nil {
-			go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId), Value: cacheBytes})
+// convertSQLJoinPolicyToProto 转换加入策略，未设置或未知的策略沿用旧逻辑：有密码为密码制，否则为公开
+func convertSQLJoinPolicyToProto(sqlJoinPolicy string, hasPassword bool) pb.JoinPolicy {
+	switch sqlJoinPolicy {
+	case "open":
+		return pb.JoinPolicy_open
+	case "password":
+		return pb.JoinPolicy_password
+	case "approval":
+		return pb.JoinPolicy_approval
+	case "invite":
+		return pb.JoinPolicy_invite
+	default:
+		if hasPassword {
+			return pb.JoinPolicy_password
 		}
+		return pb.JoinPolicy_open
 	}
-	if len(cacheObj.Members) == 0 {
-		return &pb.GetGroupInfoResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Group not found"},
-		}, nil
-	}
-
-	// 添加用户到群组
-	username, err := user.QueryUsernameByUID(ctx, req.Uid)
-	if err != nil {
-		return &pb.GetGroupInfoResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
-		}, nil
+}
+func convertProtoToSQLJoinPolicy(protoPolicy pb.JoinPolicy) string {
+	switch protoPolicy {
+	case pb.JoinPolicy_open:
+		return "open"
+	case pb.JoinPolicy_password:
+		return "password"
+	case pb.JoinPolicy_approval:
+		return "approval"
+	case pb.JoinPolicy_invite:
+		return "invite"
+	default:
+		return "invite"
 	}
+}
 
-	found := false
-	for _, element := range cacheObj.Members {
-		if element.Name == username {
-			found = true
-			break
-		}
+func convertSQLJoinMethodToProto(sqlJoinMethod string) pb.JoinMethod {
+	switch sqlJoinMethod {
+	case "creator":
+		return pb.JoinMethod_creator
+	case "password":
+		return pb.JoinMethod_password
+	case "open":
+		return pb.JoinMethod_open
+	case "invite":
+		return pb.JoinMethod_invite
+	case "link":
+		return pb.JoinMethod_link
+	case "approval":
+		return pb.JoinMethod_approval
+	default:
+		return pb.JoinMethod_unknown
 	}
-	if !found {
-		return &pb.GetGroupInfoResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
-		}, nil
+}
+func convertProtoToSQLJoinMethod(protoMethod pb.JoinMethod) string {
+	switch protoMethod {
+	case pb.JoinMethod_creator:
+		return "creator"
+	case pb.JoinMethod_password:
+		return "password"
+	case pb.JoinMethod_open:
+		return "open"
+	case pb.JoinMethod_invite:
+		return "invite"
+	case pb.JoinMethod_link:
+		return "link"
+	case pb.JoinMethod_approval:
+		return "approval"
+	default:
+		return "unknown"
 	}
+}
+
+// GetGroupInfo 获取群组信息
+func (s *server) GetGroupInfo(ctx context.Context, req *pb.GetGroupInfoRequest) (*pb.GetGroupInfoResponse, error) {
+	_, members := actorOf(ctx)
 	return &pb.GetGroupInfoResponse{
 		Result:  &pb.Result{Code: errorcode.Success},
-		Members: cacheObj.Members,
+		Members: members,
 	}, nil
 }
 
 // JoinGroup 用户加入群组
 func (s *server) JoinGroup(ctx context.Context, req *pb.JoinGroupRequest) (*pb.JoinGroupResponse, error) {
-	// 验证群组密码
-	resp, err := gateway.ExecRedisGet(&pb_gtw.RedisGetStringRequest{DBID: 0, Key: "groupuser:password:" + fmt.Sprintf("%d", req.GroupId)})
-	storedPasswordHash := ""
-	if err != nil || resp.Result.Code != errorcode.Success || len(resp.Value) == 0 || resp.Value != req.Password {
-		sqlReq := &pb_gtw.SqlRequest{
-			Sql: "SELECT `password` FROM `groups` WHERE groupid = ?",
-			Db:  pb_gtw.SqlDatabases_Groups,
-			Params: []*pb_gtw.InterFaceType{
-				{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
-			},
-		}
+	publicObj, err := loadGroupPublicCache(req.GroupId)
+	if err != nil {
+		return &pb.JoinGroupResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	if publicObj.Id == -1 {
+		return &pb.JoinGroupResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Group not found"},
+		}, nil
+	}
 
-		sqlResp, err := gateway.ExecSQL(sqlReq)
+	switch publicObj.JoinPolicy {
+	case pb.JoinPolicy_open:
+	case pb.JoinPolicy_password:
+		// 验证群组密码
+		storedPasswordHash, err := loadGroupPasswordHash(req.GroupId)
 		if err != nil {
 			return &pb.JoinGroupResponse{
 				Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
 			}, nil
 		}
-		if sqlResp.Result.Code != errorcode.Success || len(sqlResp.Data) == 0 {
+		hashedPasswordRequest := sha256.Sum256([]byte(req.Password + config.LatestConfig.Security.PasswordSalt))
+		if storedPasswordHash == "" || hex.EncodeToString(hashedPasswordRequest[:]) != storedPasswordHash {
 			return &pb.JoinGroupResponse{
-				Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Group not found"},
+				Result: &pb.Result{Code: errorcode.GroupUserPasswordIncorrect, Msg: "Password incorrect"},
 			}, nil
 		}
-
-		storedPasswordHash = sqlResp.Data[0].Result[0].GetStr()
-		if storedPasswordHash == "" {
-			return &pb.JoinGroupResponse{
-				Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Group not found"},
-			}, nil
-		}
-		go gateway.ExecRedisSet(&pb_gtw.RedisSetStringRequest{DBID: 0, Key: "groupuser:password:" + fmt.Sprintf("%d", req.GroupId), Value: storedPasswordHash})
-	} else {
-		storedPasswordHash = resp.Value
-	}
-
-	if storedPasswordHash == "" {
+	case pb.JoinPolicy_approval:
 		return &pb.JoinGroupResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Group not found"},
+			Result: &pb.Result{Code: errorcode.GroupUserApprovalRequired, Msg: "Approval required"},
 		}, nil
-	}
-
-	hashedPasswordRequest := sha256.Sum256([]byte(req.Password + config.LatestConfig.Security.PasswordSalt))
-	if hex.EncodeToString(hashedPasswordRequest[:]) != storedPasswordHash {
+	default:
 		return &pb.JoinGroupResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserPasswordIncorrect, Msg: "Password incorrect"},
+			Result: &pb.Result{Code: errorcode.GroupUserInviteOnly, Msg: "Invite only"},
 		}, nil
 	}
 
@@ -285,50 +211,21 @@ func (s *server) JoinGroup(ctx context.Context, req *pb.JoinGroupRequest) (*pb.J
 		}, nil
 	}
 
-	respCache, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
-	cacheObj := &pb.GetGroupInfoCache{}
-	if !(err != nil || resp.Result.Code != errorcode.Success || len(resp.Value) == 0 || (proto.Unmarshal(respCache.Value, cacheObj) != nil)) {
-		for _, element := range cacheObj.Members {
-			if element.Name == username {
-				return &pb.JoinGroupResponse{
-					Result: &pb.Result{Code: errorcode.GroupUserAlreadyInGroup, Msg: "User already in group"},
-				}, nil
-			}
-		}
-	}
-
-	insertReq := &pb_gtw.SqlRequest{
-		Sql:    "INSERT INTO group_user_table (groupid, username, type) VALUES (?, ?, 'member')",
-		Db:     pb_gtw.SqlDatabases_Groups,
-		Commit: true,
-		Params: []*pb_gtw.InterFaceType{
-			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
-			{Response: &pb_gtw.InterFaceType_Str{Str: username}},
-		},
-		GetRowCount: true,
-	}
-
-	insertResp, err := gateway.ExecSQL(insertReq)
-
-	if err != nil {
-		return &pb.JoinGroupResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Insert error: %v", err)}}, nil
-	}
-
-	if insertResp.Result.Code != errorcode.Success {
-		return &pb.JoinGroupResponse{
-			Result: &pb.Result{Code: insertResp.Result.Code, Msg: insertResp.Result.Msg},
-		}, nil
-	}
-
-	if insertResp.RowsAffected == 0 {
+	cacheObj, err := loadGroupInfoCache(req.GroupId)
+	if err == nil && findMember(cacheObj.Members, username) != nil {
 		return &pb.JoinGroupResponse{
 			Result: &pb.Result{Code: errorcode.GroupUserAlreadyInGroup, Msg: "User already in group"},
 		}, nil
 	}
 
-	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:groups:" + fmt.Sprintf("%d", req.Uid)})
-	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
+	joinMethod := pb.JoinMethod_open
+	if publicObj.JoinPolicy == pb.JoinPolicy_password {
+		joinMethod = pb.JoinMethod_password
+	}
+	if res := insertGroupMember(req.GroupId, username, req.Uid, 0, joinMethod); res.Code != errorcode.Success {
+		return &pb.JoinGroupResponse{Result: res}, nil
+	}
+	appendAuditLog(req.GroupId, req.Uid, pb.AuditAction_join, username, "", convertProtoToSQLJoinMethod(joinMethod))
 	return &pb.JoinGroupResponse{
 		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
 	}, nil
@@ -336,110 +233,61 @@ func (s *server) JoinGroup(ctx context.Context, req *pb.JoinGroupRequest) (*pb.J
 
 // InviteGroup 用户被拉入群组
 func (s *server) InviteGroup(ctx context.Context, req *pb.InviteGroupRequest) (*pb.InviteGroupResponse, error) {
-	username, err := user.QueryUsernameByUID(ctx, req.Uid)
-	if err != nil {
+	self, members := actorOf(ctx)
+	if !user.QueryHasUsername(ctx, req.Username) {
 		return &pb.InviteGroupResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "User not found"},
 		}, nil
 	}
-
-	if !user.QueryHasUsername(ctx, req.Username) {
+	if findMember(members, req.Username) != nil {
 		return &pb.InviteGroupResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "User not found"},
+			Result: &pb.Result{Code: errorcode.GroupUserAlreadyInGroup, Msg: "User already in group"},
 		}, nil
 	}
 
-	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
-	cacheObj := &pb.GetGroupInfoCache{}
-	if err != nil || resp.Result.Code != errorcode.Success || len(resp.Value) == 0 || (proto.Unmarshal(resp.Value, cacheObj) != nil) {
-		for { // 利用控制流跳出
-			sqlReq := &pb_gtw.SqlRequest{
-				Sql: "SELECT `username`, CAST(`type` AS CHAR) FROM `group_user_table` WHERE groupid = ?",
-				Db:  pb_gtw.SqlDatabases_Groups,
-				Params: []*pb_gtw.InterFaceType{
-					{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
-				},
-			}
-
-			sqlResp, err := gateway.ExecSQL(sqlReq)
-			if err != nil {
-				return &pb.InviteGroupResponse{
-					Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
-				}, nil
-			}
-			if sqlResp.Result.Code != errorcode.Success || len(sqlResp.Data) == 0 {
-				break
-			}
-
-			for _, row := range sqlResp.Data {
-				cacheObj.Members = append(cacheObj.Members, &pb.MemberObject{
-					Name: row.Result[0].GetStr(),
-					Type: convertSQLUserTypeToProto(row.Result[1].GetStr()),
-				})
-			}
-			if true { // 保证始终跳出
-				break
-			}
-		}
-		cacheBytes, err := proto.Marshal(cacheObj)
-		if err == nil {
-			go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId), Value: cacheBytes})
-		}
-	}
-	if len(cacheObj.Members) == 0 {
+	// 审核制与邀请制群组只允许管理员以上邀请
+	publicObj, err := loadGroupPublicCache(req.GroupId)
+	if err != nil {
 		return &pb.InviteGroupResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Group not found"},
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
 		}, nil
 	}
-	found := false
-	for _, element := range cacheObj.Members {
-		switch element.Name {
-		case username:
-			found = true
-		case req.Username:
-			return &pb.InviteGroupResponse{
-				Result: &pb.Result{Code: errorcode.GroupUserAlreadyInGroup, Msg: "User already in group"},
-			}, nil
-		}
-	}
-	if !found {
+	if (publicObj.JoinPolicy == pb.JoinPolicy_approval || publicObj.JoinPolicy == pb.JoinPolicy_invite) && !outranksType(self, pb.MemberType_member) {
 		return &pb.InviteGroupResponse{
 			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
 		}, nil
 	}
 
-	insertReq := &pb_gtw.SqlRequest{
-		Sql:    "INSERT INTO group_user_table (`groupid`, `username`, `type`) VALUES (?, ?, 'member')",
-		Db:     pb_gtw.SqlDatabases_Groups,
-		Commit: true,
-		Params: []*pb_gtw.InterFaceType{
-			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
-			{Response: &pb_gtw.InterFaceType_Str{Str: req.Username}},
-		},
-	}
-
-	insertResp, err := gateway.ExecSQL(insertReq)
-
+	inviteeUID, err := user.QueryUIDByUsername(ctx, req.Username)
 	if err != nil {
 		return &pb.InviteGroupResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Insert error: %v", err)}}, nil
+			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+		}, nil
 	}
 
-	if insertResp.Result.Code != errorcode.Success {
+	if res := checkBanned(req.GroupId, inviteeUID); res != nil {
+		return &pb.InviteGroupResponse{Result: res}, nil
+	}
+
+	// 受信任的群组直接拉入，否则生成待被邀请者确认的邀请
+	if publicObj.IsDirectInvite {
+		if res := insertGroupMember(req.GroupId, req.Username, inviteeUID, req.Uid, pb.JoinMethod_invite); res.Code != errorcode.Success {
+			return &pb.InviteGroupResponse{Result: res}, nil
+		}
+		appendAuditLog(req.GroupId, req.Uid, pb.AuditAction_invite, req.Username, "", "member")
 		return &pb.InviteGroupResponse{
-			Result: &pb.Result{Code: insertResp.Result.Code, Msg: insertResp.Result.Msg},
+			Result: &pb.Result{Code: errorcode.Success, Msg: ""},
 		}, nil
 	}
-	go func() {
-		userID, err := user.QueryUIDByUsername(ctx, req.Username)
-		if err != nil {
-			return
-		}
-		gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:groups:" + fmt.Sprintf("%d", userID)})
-	}()
-	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
+	invitationID, res := insertInvitation(req.GroupId, req.Uid, inviteeUID, req.Username)
+	if res != nil {
+		return &pb.InviteGroupResponse{Result: res}, nil
+	}
+	appendAuditLog(req.GroupId, req.Uid, pb.AuditAction_invite, req.Username, "", "pending")
 	return &pb.InviteGroupResponse{
-		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+		Result:       &pb.Result{Code: errorcode.Success, Msg: ""},
+		IsPending:    true,
+		InvitationId: invitationID,
 	}, nil
 }
 
@@ -452,15 +300,36 @@ func (s *server) CreateGroup(ctx context.Context, req *pb.CreateGroupRequest) (*
 			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
 		}, nil
 	}
-	hashedPasswordRequest := sha256.Sum256([]byte("" + config.LatestConfig.Security.PasswordSalt))
+	// 未指定策略时为公开，此时提供密码沿用旧逻辑视为密码制；仅密码制群组保存密码，其余策略不接受密码
+	joinPolicy := req.JoinPolicy
+	if joinPolicy == pb.JoinPolicy_open && req.Password != "" {
+		joinPolicy = pb.JoinPolicy_password
+	}
+	if joinPolicy != pb.JoinPolicy_password && req.Password != "" {
+		return &pb.CreateGroupResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Password not allowed"},
+		}, nil
+	}
+	storedPasswordHash := ""
+	if joinPolicy == pb.JoinPolicy_password {
+		if req.Password == "" {
+			return &pb.CreateGroupResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Password required"},
+			}, nil
+		}
+		hashedPasswordRequest := sha256.Sum256([]byte(req.Password + config.LatestConfig.Security.PasswordSalt))
+		storedPasswordHash = hex.EncodeToString(hashedPasswordRequest[:])
+	}
 	insertReq := &pb_gtw.SqlRequest{
-		Sql:    "INSERT INTO `groups` (`password`, `name`, `owner_uid`) VALUES (?, ?, ?)",
+		Sql:    "INSERT INTO `groups` (`password`, `name`, `owner_uid`, `join_policy`, `is_direct_invite`) VALUES (?, ?, ?, ?, ?)",
 		Db:     pb_gtw.SqlDatabases_Groups,
 		Commit: true,
 		Params: []*pb_gtw.InterFaceType{
-			{Response: &pb_gtw.InterFaceType_Str{Str: hex.EncodeToString(hashedPasswordRequest[:])}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: storedPasswordHash}},
 			{Response: &pb_gtw.InterFaceType_Str{Str: req.Name}},
 			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.Uid}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: convertProtoToSQLJoinPolicy(joinPolicy)}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: boolToInt32(req.IsDirectInvite)}},
 		},
 		GetLastInsertId: true,
 	}
@@ -480,7 +349,7 @@ func (s *server) CreateGroup(ctx context.Context, req *pb.CreateGroupRequest) (*
 	}
 
 	insertReq2 := &pb_gtw.SqlRequest{
-		Sql:    "INSERT INTO `group_user_table` (`groupid`, `username`, `type`) VALUES (?, ?, 'owner')",
+		Sql:    "INSERT INTO `group_user_table` (`groupid`, `username`, `type`, `joined_at`, `invited_by_uid`, `join_method`) VALUES (?, ?, 'owner', UNIX_TIMESTAMP(), 0, 'creator')",
 		Db:     pb_gtw.SqlDatabases_Groups,
 		Commit: true,
 		Params: []*pb_gtw.InterFaceType{
@@ -489,6 +358,8 @@ func (s *server) CreateGroup(ctx context.Context, req *pb.CreateGroupRequest) (*
 		},
 	}
 
+	ev := newGroupEvent(int32(insertResp.LastInsertId), pb.GroupEventType_group_created, req.Uid, username, "", req.Name)
+	outbox.Attach(insertReq2, ev)
 	respInst, err := gateway.ExecSQL(insertReq2)
 	if err != nil || respInst.Result.Code != errorcode.Success {
 		go func() {
@@ -507,6 +378,10 @@ func (s *server) CreateGroup(ctx context.Context, req *pb.CreateGroupRequest) (*
 	}
 
 	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:groups:" + fmt.Sprintf("%d", req.Uid)})
+	// 清除创建前可能写入的不存在缓存
+	gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:public:" + fmt.Sprintf("%d", insertResp.LastInsertId)})
+	appendAuditLog(int32(insertResp.LastInsertId), req.Uid, pb.AuditAction_create_group, username, "", req.Name)
+	event.Publish(ev)
 
 	return &pb.CreateGroupResponse{
 		Result:  &pb.Result{Code: errorcode.Success, Msg: ""},
@@ -516,80 +391,25 @@ func (s *server) CreateGroup(ctx context.Context, req *pb.CreateGroupRequest) (*
 
 // SetUserType 设置用户类型
 func (s *server) SetUserType(ctx context.Context, req *pb.SetUserTypeRequest) (*pb.SetUserTypeResponse, error) {
-	username, err := user.QueryUsernameByUID(ctx, req.Uid)
-	if err != nil {
+	// 群主只能通过 TransferOwnership 转让
+	if req.Type == pb.MemberType_owner {
 		return &pb.SetUserTypeResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Use TransferOwnership to change owner"},
 		}, nil
 	}
-
-	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
-	cacheObj := &pb.GetGroupInfoCache{}
-	if err != nil || resp.Result.Code != errorcode.Success || len(resp.Value) == 0 || (proto.Unmarshal(resp.Value, cacheObj) != nil) {
-		for { // 利用控制流跳出
-			sqlReq := &pb_gtw.SqlRequest{
-				Sql: "SELECT `username`, CAST(`type` AS CHAR) FROM `group_user_table` WHERE groupid = ?",
-				Db:  pb_gtw.SqlDatabases_Groups,
-				Params: []*pb_gtw.InterFaceType{
-					{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
-				},
-			}
-
-			sqlResp, err := gateway.ExecSQL(sqlReq)
-			if err != nil {
-				return &pb.SetUserTypeResponse{
-					Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
-				}, nil
-			}
-			if sqlResp.Result.Code != errorcode.Success || len(sqlResp.Data) == 0 {
-				break
-			}
-
-			for _, row := range sqlResp.Data {
-				cacheObj.Members = append(cacheObj.Members, &pb.MemberObject{
-					Name: row.Result[0].GetStr(),
-					Type: convertSQLUserTypeToProto(row.Result[1].GetStr()),
-				})
-			}
-			if true { // 保证始终跳出
-				break
-			}
-		}
-		cacheBytes, err := proto.Marshal(cacheObj)
-		if err == nil {
-			go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId), Value: cacheBytes})
-		}
-	}
-	if len(cacheObj.Members) == 0 {
+	self, members := actorOf(ctx)
+	target := findMember(members, req.Username)
+	if target == nil {
 		return &pb.SetUserTypeResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Group not found"},
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "User not found"},
 		}, nil
 	}
-	found := false
-	foundDist := false
-	for _, element := range cacheObj.Members {
-		switch element.Name {
-		case username:
-			found = true
-			if element.Type != pb.MemberType_owner {
-				return &pb.SetUserTypeResponse{
-					Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
-				}, nil
-			}
-		case req.Username:
-			foundDist = true
-		}
-	}
-	if !found {
+	// 只能调整低于自己的成员，且只能授予低于自己的身份
+	if !outranks(self, target) || !outranksType(self, req.Type) {
 		return &pb.SetUserTypeResponse{
 			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
 		}, nil
 	}
-	if !foundDist {
-		return &pb.SetUserTypeResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "User not found"},
-		}, nil
-	}
 
 	insertReq := &pb_gtw.SqlRequest{
 		Sql:    "UPDATE `group_user_table` SET `type` = ? WHERE `groupid` = ? AND `username` = ?",
@@ -602,6 +422,8 @@ func (s *server) SetUserType(ctx context.Context, req *pb.SetUserTypeRequest) (*
 		},
 	}
 
+	ev := newGroupEvent(req.GroupId, pb.GroupEventType_role_changed, req.Uid, req.Username, target.Role, convertProtoToSQLUserType(req.Type))
+	outbox.Attach(insertReq, ev)
 	insertResp, err := gateway.ExecSQL(insertReq)
 
 	if err != nil {
@@ -615,6 +437,9 @@ func (s *server) SetUserType(ctx context.Context, req *pb.SetUserTypeRequest) (*
 		}, nil
 	}
 	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
+	delMemberCache(req.GroupId, req.Username)
+	appendAuditLog(req.GroupId, req.Uid, pb.AuditAction_set_user_type, req.Username, convertProtoToSQLUserType(target.Type), convertProtoToSQLUserType(req.Type))
+	event.Publish(ev)
 	return &pb.SetUserTypeResponse{
 		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
 	}, nil
@@ -622,69 +447,10 @@ func (s *server) SetUserType(ctx context.Context, req *pb.SetUserTypeRequest) (*
 
 // ChangeGroupName 设置群名
 func (s *server) ChangeGroupName(ctx context.Context, req *pb.ChangeGroupNameRequest) (*pb.ChangeGroupNameResponse, error) {
-	username, err := user.QueryUsernameByUID(ctx, req.Uid)
+	publicObj, err := loadGroupPublicCache(req.GroupId)
 	if err != nil {
 		return &pb.ChangeGroupNameResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
-		}, nil
-	}
-
-	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
-	cacheObj := &pb.GetGroupInfoCache{}
-	if err != nil || resp.Result.Code != errorcode.Success || len(resp.Value) == 0 || (proto.Unmarshal(resp.Value, cacheObj) != nil) {
-		for { // 利用控制流跳出
-			sqlReq := &pb_gtw.SqlRequest{
-				Sql: "SELECT `username`, CAST(`type` AS CHAR) FROM `group_user_table` WHERE groupid = ?",
-				Db:  pb_gtw.SqlDatabases_Groups,
-				Params: []*pb_gtw.InterFaceType{
-					{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
-				},
-			}
-
-			sqlResp, err := gateway.ExecSQL(sqlReq)
-			if err != nil {
-				return &pb.ChangeGroupNameResponse{
-					Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
-				}, nil
-			}
-			if sqlResp.Result.Code != errorcode.Success || len(sqlResp.Data) == 0 {
-				break
-			}
-
-			for _, row := range sqlResp.Data {
-				cacheObj.Members = append(cacheObj.Members, &pb.MemberObject{
-					Name: row.Result[0].GetStr(),
-					Type: convertSQLUserTypeToProto(row.Result[1].GetStr()),
-				})
-			}
-			if true { // 保证始终跳出
-				break
-			}
-		}
-		cacheBytes, err := proto.Marshal(cacheObj)
-		if err == nil {
-			go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId), Value: cacheBytes})
-		}
-	}
-	if len(cacheObj.Members) == 0 {
-		return &pb.ChangeGroupNameResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Group not found"},
-		}, nil
-	}
-	found := false
-	for _, element := range cacheObj.Members {
-		if element.Name == username {
-			found = true
-			if element.Type != pb.MemberType_owner && element.Type != pb.MemberType_manager {
-				return &pb.ChangeGroupNameResponse{
-					Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
-				}, nil
-			}
-		}
-	}
-	if !found {
-		return &pb.ChangeGroupNameResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
 		}, nil
 	}
 
@@ -698,6 +464,8 @@ func (s *server) ChangeGroupName(ctx context.Context, req *pb.ChangeGroupNameReq
 		},
 	}
 
+	ev := newGroupEvent(req.GroupId, pb.GroupEventType_group_renamed, req.Uid, "", publicObj.Name, req.Name)
+	outbox.Attach(insertReq, ev)
 	insertResp, err := gateway.ExecSQL(insertReq)
 
 	if err != nil {
@@ -711,6 +479,8 @@ func (s *server) ChangeGroupName(ctx context.Context, req *pb.ChangeGroupNameReq
 		}, nil
 	}
 	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:public:" + fmt.Sprintf("%d", req.GroupId)})
+	appendAuditLog(req.GroupId, req.Uid, pb.AuditAction_rename, "", publicObj.Name, req.Name)
+	event.Publish(ev)
 	return &pb.ChangeGroupNameResponse{
 		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
 	}, nil
@@ -718,83 +488,41 @@ func (s *server) ChangeGroupName(ctx context.Context, req *pb.ChangeGroupNameReq
 
 // ChangeGroupPassword 设置群名密码
 func (s *server) ChangeGroupPassword(ctx context.Context, req *pb.ChangeGroupPasswordRequest) (*pb.ChangeGroupPasswordResponse, error) {
-	username, err := user.QueryUsernameByUID(ctx, req.Uid)
+	publicObj, err := loadGroupPublicCache(req.GroupId)
 	if err != nil {
 		return &pb.ChangeGroupPasswordResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
 		}, nil
 	}
 
-	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
-	cacheObj := &pb.GetGroupInfoCache{}
-	if err != nil || resp.Result.Code != errorcode.Success || len(resp.Value) == 0 || (proto.Unmarshal(resp.Value, cacheObj) != nil) {
-		for { // 利用控制流跳出
-			sqlReq := &pb_gtw.SqlRequest{
-				Sql: "SELECT `username`, CAST(`type` AS CHAR) FROM `group_user_table` WHERE groupid = ?",
-				Db:  pb_gtw.SqlDatabases_Groups,
-				Params: []*pb_gtw.InterFaceType{
-					{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
-				},
-			}
-
-			sqlResp, err := gateway.ExecSQL(sqlReq)
-			if err != nil {
-				return &pb.ChangeGroupPasswordResponse{
-					Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
-				}, nil
-			}
-			if sqlResp.Result.Code != errorcode.Success || len(sqlResp.Data) == 0 {
-				break
-			}
-
-			for _, row := range sqlResp.Data {
-				cacheObj.Members = append(cacheObj.Members, &pb.MemberObject{
-					Name: row.Result[0].GetStr(),
-					Type: convertSQLUserTypeToProto(row.Result[1].GetStr()),
-				})
-			}
-			if true { // 保证始终跳出
-				break
-			}
-		}
-		cacheBytes, err := proto.Marshal(cacheObj)
-		if err == nil {
-			go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId), Value: cacheBytes})
-		}
-	}
-	if len(cacheObj.Members) == 0 {
-		return &pb.ChangeGroupPasswordResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Group not found"},
-		}, nil
-	}
-	found := false
-	for _, element := range cacheObj.Members {
-		if element.Name == username {
-			found = true
-			if element.Type != pb.MemberType_owner && element.Type != pb.MemberType_manager {
-				return &pb.ChangeGroupPasswordResponse{
-					Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
-				}, nil
-			}
-		}
+	// 公开与密码制群组随密码切换策略：设置密码即切换为密码制，清空密码则恢复为公开
+	// 审核制与仅邀请群组只更新密码，策略由 SetJoinPolicy 修改
+	storedPasswordHash := ""
+	passwordPolicy := pb.JoinPolicy_open
+	if req.Password != "" {
+		hashedPasswordRequest := sha256.Sum256([]byte(req.Password + config.LatestConfig.Security.PasswordSalt))
+		storedPasswordHash = hex.EncodeToString(hashedPasswordRequest[:])
+		passwordPolicy = pb.JoinPolicy_password
 	}
-	if !found {
-		return &pb.ChangeGroupPasswordResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
-		}, nil
+	joinPolicy := publicObj.JoinPolicy
+	if joinPolicy == pb.JoinPolicy_open || joinPolicy == pb.JoinPolicy_password {
+		joinPolicy = passwordPolicy
 	}
-
-	hashedPasswordRequest := sha256.Sum256([]byte(req.Password + config.LatestConfig.Security.PasswordSalt))
 	insertReq := &pb_gtw.SqlRequest{
-		Sql:    "UPDATE `groups` SET `password` = ? WHERE `groupid` = ?",
+		Sql:    "UPDATE `groups` SET `password` = ?, `join_policy` = IF(`join_policy` IN ('open', 'password'), ?, `join_policy`) WHERE `groupid` = ?",
 		Db:     pb_gtw.SqlDatabases_Groups,
 		Commit: true,
 		Params: []*pb_gtw.InterFaceType{
-			{Response: &pb_gtw.InterFaceType_Str{Str: hex.EncodeToString(hashedPasswordRequest[:])}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: storedPasswordHash}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: convertProtoToSQLJoinPolicy(passwordPolicy)}},
 			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
 		},
 	}
 
+	ev := newGroupEvent(req.GroupId, pb.GroupEventType_join_policy_changed, req.Uid, "", convertProtoToSQLJoinPolicy(publicObj.JoinPolicy), convertProtoToSQLJoinPolicy(joinPolicy))
+	if joinPolicy != publicObj.JoinPolicy {
+		outbox.Attach(insertReq, ev)
+	}
 	insertResp, err := gateway.ExecSQL(insertReq)
 
 	if err != nil {
@@ -808,6 +536,12 @@ func (s *server) ChangeGroupPassword(ctx context.Context, req *pb.ChangeGroupPas
 		}, nil
 	}
 	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:public:" + fmt.Sprintf("%d", req.GroupId)})
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:password:" + fmt.Sprintf("%d", req.GroupId)})
+	// 审计记录只保存加入策略的变化，不保存密码
+	appendAuditLog(req.GroupId, req.Uid, pb.AuditAction_change_password, "", convertProtoToSQLJoinPolicy(publicObj.JoinPolicy), convertProtoToSQLJoinPolicy(joinPolicy))
+	if joinPolicy != publicObj.JoinPolicy {
+		event.Publish(ev)
+	}
 	return &pb.ChangeGroupPasswordResponse{
 		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
 	}, nil
@@ -815,80 +549,30 @@ func (s *server) ChangeGroupPassword(ctx context.Context, req *pb.ChangeGroupPas
 
 // KickUser 踢出群成员
 func (s *server) KickUser(ctx context.Context, req *pb.KickUserRequest) (*pb.KickUserResponse, error) {
-	username, err := user.QueryUsernameByUID(ctx, req.Uid)
-	if err != nil {
+	self, members := actorOf(ctx)
+	target := findMember(members, req.Username)
+	if target == nil {
 		return &pb.KickUserResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "User not found"},
 		}, nil
 	}
-
-	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
-	cacheObj := &pb.GetGroupInfoCache{}
-	if err != nil || resp.Result.Code != errorcode.Success || len(resp.Value) == 0 || (proto.Unmarshal(resp.Value, cacheObj) != nil) {
-		for { // 利用控制流跳出
-			sqlReq := &pb_gtw.SqlRequest{
-				Sql: "SELECT `username`, CAST(`type` AS CHAR) FROM `group_user_table` WHERE groupid = ?",
-				Db:  pb_gtw.SqlDatabases_Groups,
-				Params: []*pb_gtw.InterFaceType{
-					{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
-				},
-			}
-
-			sqlResp, err := gateway.ExecSQL(sqlReq)
-			if err != nil {
-				return &pb.KickUserResponse{
-					Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
-				}, nil
-			}
-			if sqlResp.Result.Code != errorcode.Success || len(sqlResp.Data) == 0 {
-				break
-			}
-
-			for _, row := range sqlResp.Data {
-				cacheObj.Members = append(cacheObj.Members, &pb.MemberObject{
-					Name: row.Result[0].GetStr(),
-					Type: convertSQLUserTypeToProto(row.Result[1].GetStr()),
-				})
-			}
-			if true { // 保证始终跳出
-				break
-			}
-		}
-		cacheBytes, err := proto.Marshal(cacheObj)
-		if err == nil {
-			go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId), Value: cacheBytes})
-		}
-	}
-	if len(cacheObj.Members) == 0 {
+	// 移除群主会使群组失去群主
+	if target.Type == pb.MemberType_owner {
 		return &pb.KickUserResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Group not found"},
+			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
 		}, nil
 	}
-	found := false
-	foundDist := false
-	for _, element := range cacheObj.Members {
-		if element.Name == username {
-			found = true
-			if element.Type != pb.MemberType_owner && element.Type != pb.MemberType_manager && req.Username != username {
-				return &pb.KickUserResponse{
-					Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
-				}, nil
-			}
+	// 踢出他人需要踢人权限，且只能踢出低于自己的成员
+	if req.Username != self.Name {
+		if res := checkPermission(req.GroupId, self, pb.GroupAction_kick); res != nil {
+			return &pb.KickUserResponse{Result: res}, nil
 		}
-		if element.Name == req.Username {
-			foundDist = true
+		if !outranks(self, target) {
+			return &pb.KickUserResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
+			}, nil
 		}
 	}
-	if !found {
-		return &pb.KickUserResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"},
-		}, nil
-	}
-	if !foundDist {
-		return &pb.KickUserResponse{
-			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "User not found"},
-		}, nil
-	}
 
 	insertReq := &pb_gtw.SqlRequest{
 		Sql:    "DELETE FROM `group_user_table` WHERE `groupid` = ? AND `username` = ?",
@@ -900,6 +584,12 @@ func (s *server) KickUser(ctx context.Context, req *pb.KickUserRequest) (*pb.Kic
 		},
 	}
 
+	eventType := pb.GroupEventType_member_kicked
+	if req.Username == self.Name {
+		eventType = pb.GroupEventType_member_left
+	}
+	ev := newGroupEvent(req.GroupId, eventType, req.Uid, req.Username, "", "")
+	outbox.Attach(insertReq, ev)
 	insertResp, err := gateway.ExecSQL(insertReq)
 
 	if err != nil {
@@ -913,13 +603,17 @@ func (s *server) KickUser(ctx context.Context, req *pb.KickUserRequest) (*pb.Kic
 		}, nil
 	}
 	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", req.GroupId)})
-	go func() {
-		userID, err := user.QueryUIDByUsername(ctx, req.Username)
-		if err != nil {
-			return
-		}
-		gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:groups:" + fmt.Sprintf("%d", userID)})
-	}()
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:public:" + fmt.Sprintf("%d", req.GroupId)})
+	delMemberCache(req.GroupId, req.Username)
+	appendAuditLog(req.GroupId, req.Uid, pb.AuditAction_kick, req.Username, convertProtoToSQLUserType(target.Type), "")
+	event.Publish(ev)
+	userID, err := user.QueryUIDByUsername(ctx, req.Username)
+	if err != nil {
+		log.Printf("[GRPC]Kick cleanup error: %v\n", err)
+	} else {
+		clearMute(req.GroupId, userID)
+		go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:groups:" + fmt.Sprintf("%d", userID)})
+	}
 	return &pb.KickUserResponse{
 		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
 	}, nil
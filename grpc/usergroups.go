@@ -0,0 +1,123 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+	"StealthIMGroupUser/user"
+)
+
+// listGroupSummaries 按群组 ID 分页组装用户所在群组的摘要信息
+func listGroupSummaries(ctx context.Context, req *pb.GetGroupsByUIDRequest, cacheObj *pb.GetGroupsByUIDCache) *pb.GetGroupsByUIDResponse {
+	username, err := user.QueryUsernameByUID(ctx, req.Uid)
+	if err != nil {
+		return &pb.GetGroupsByUIDResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)},
+		}
+	}
+	groupIDs := slices.Clone(cacheObj.Groups)
+	slices.Sort(groupIDs)
+
+	limit := normalizeLimit(req.Limit)
+	var summaries []*pb.GroupSummary
+	scanned := 0
+	nextCursor := int32(0)
+	for _, groupID := range groupIDs {
+		if groupID <= req.Cursor {
+			continue
+		}
+		if scanned == int(limit) {
+			break
+		}
+		scanned++
+		nextCursor = groupID
+		publicObj, err := loadGroupPublicCache(groupID)
+		if err != nil {
+			return &pb.GetGroupsByUIDResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+			}
+		}
+		member, err := loadMemberCache(groupID, username)
+		if err != nil {
+			return &pb.GetGroupsByUIDResponse{
+				Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+			}
+		}
+		// 群组列表缓存可能落后于成员变化，跳过已失效的群组
+		if publicObj.Id == -1 || member == nil {
+			continue
+		}
+		summaries = append(summaries, &pb.GroupSummary{
+			GroupId:       groupID,
+			Name:          publicObj.Name,
+			Type:          member.Type,
+			Role:          member.Role,
+			MemberCount:   publicObj.MemberCount,
+			JoinedAt:      member.JoinedAt,
+			IsPinned:      slices.Contains(cacheObj.Pinned, groupID),
+			IsNotifyMuted: slices.Contains(cacheObj.NotifyMuted, groupID),
+		})
+	}
+	if scanned < int(limit) {
+		nextCursor = 0
+	}
+	return &pb.GetGroupsByUIDResponse{
+		Result:     &pb.Result{Code: errorcode.Success},
+		Groups:     cacheObj.Groups,
+		Summaries:  summaries,
+		NextCursor: nextCursor,
+	}
+}
+
+// SetGroupPreference 设置用户对群组的置顶与免打扰
+func (s *server) SetGroupPreference(ctx context.Context, req *pb.SetGroupPreferenceRequest) (*pb.SetGroupPreferenceResponse, error) {
+	if req.IsPinned == nil && req.IsNotifyMuted == nil {
+		return &pb.SetGroupPreferenceResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Nothing to update"},
+		}, nil
+	}
+	self, _ := actorOf(ctx)
+	username := self.Name
+
+	var sets []string
+	var params []*pb_gtw.InterFaceType
+	if req.IsPinned != nil {
+		sets = append(sets, "`is_pinned` = ?")
+		params = append(params, &pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Int32{Int32: boolToInt32(req.GetIsPinned())}})
+	}
+	if req.IsNotifyMuted != nil {
+		sets = append(sets, "`is_notify_muted` = ?")
+		params = append(params, &pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Int32{Int32: boolToInt32(req.GetIsNotifyMuted())}})
+	}
+	params = append(params,
+		&pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+		&pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Str{Str: username}},
+	)
+	updateReq := &pb_gtw.SqlRequest{
+		Sql:    "UPDATE `group_user_table` SET " + strings.Join(sets, ", ") + " WHERE `groupid` = ? AND `username` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: params,
+	}
+	updateResp, err := gateway.ExecSQL(updateReq)
+	if err != nil {
+		return &pb.SetGroupPreferenceResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Update error: %v", err)},
+		}, nil
+	}
+	if updateResp.Result.Code != errorcode.Success {
+		return &pb.SetGroupPreferenceResponse{
+			Result: &pb.Result{Code: updateResp.Result.Code, Msg: updateResp.Result.Msg},
+		}, nil
+	}
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:groups:" + fmt.Sprintf("%d", req.Uid)})
+	return &pb.SetGroupPreferenceResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
@@ -0,0 +1,419 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/config"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/event"
+	"StealthIMGroupUser/gateway"
+	"StealthIMGroupUser/outbox"
+	"StealthIMGroupUser/user"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// defaultPageLimit 默认分页大小
+	defaultPageLimit int32 = 20
+	// maxPageLimit 最大分页大小
+	maxPageLimit int32 = 100
+	// maxTextLength 附言等文本的最大长度
+	maxTextLength = 256
+	// maxNicknameLength 群昵称与头衔的最大长度
+	maxNicknameLength = 32
+)
+
+// memberSelect 查询群成员及其自定义角色的 SQL 前缀，表别名 t1 为成员表
+const memberSelect = "SELECT t1.`username`, CAST(t1.`type` AS CHAR), IFNULL(t2.`id`, 0), IFNULL(t2.`name`, ''), IFNULL(t2.`rank`, 0), t1.`nickname`, t1.`title`, " +
+	"t1.`joined_at`, t1.`invited_by_uid`, CAST(t1.`join_method` AS CHAR) " +
+	"FROM `group_user_table` AS t1 LEFT JOIN `group_role` AS t2 ON t2.`id` = t1.`role_id` AND t2.`groupid` = t1.`groupid` "
+
+// parseMembers 解析成员查询结果
+func parseMembers(sqlResp *pb_gtw.SqlResponse) []*pb.MemberObject {
+	var members []*pb.MemberObject
+	for _, row := range sqlResp.Data {
+		if len(row.Result) < 10 {
+			continue
+		}
+		member := &pb.MemberObject{
+			Name:         row.Result[0].GetStr(),
+			Type:         convertSQLUserTypeToProto(row.Result[1].GetStr()),
+			RoleId:       row.Result[2].GetInt64(),
+			Role:         row.Result[3].GetStr(),
+			Rank:         row.Result[4].GetInt32(),
+			Nickname:     row.Result[5].GetStr(),
+			Title:        row.Result[6].GetStr(),
+			JoinedAt:     row.Result[7].GetInt64(),
+			InvitedByUid: row.Result[8].GetInt32(),
+			JoinMethod:   convertSQLJoinMethodToProto(row.Result[9].GetStr()),
+		}
+		// 未分配自定义角色时以内置身份作为角色
+		if member.RoleId == 0 {
+			member.Role = convertProtoToSQLUserType(member.Type)
+			member.Rank = int32(memberRank(member.Type))
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+// queryGroupMembers 从数据库读取群成员列表及其自定义角色
+func queryGroupMembers(groupID int32) ([]*pb.MemberObject, error) {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: memberSelect + "WHERE t1.groupid = ?",
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return nil, err
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return nil, fmt.Errorf("[%d]%s", sqlResp.Result.Code, sqlResp.Result.Msg)
+	}
+	return parseMembers(sqlResp), nil
+}
+
+// loadGroupInfoCache 读取群成员缓存，未命中时回源数据库
+func loadGroupInfoCache(groupID int32) (*pb.GetGroupInfoCache, error) {
+	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", groupID)})
+	cacheObj := &pb.GetGroupInfoCache{}
+	if err == nil && resp.Result.Code == errorcode.Success && len(resp.Value) > 0 && proto.Unmarshal(resp.Value, cacheObj) == nil {
+		return cacheObj, nil
+	}
+	members, err := queryGroupMembers(groupID)
+	if err != nil {
+		return nil, err
+	}
+	cacheObj = &pb.GetGroupInfoCache{Members: members}
+	cacheBytes, err := proto.Marshal(cacheObj)
+	if err == nil {
+		go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", groupID), Value: cacheBytes})
+	}
+	return cacheObj, nil
+}
+
+// findMember 在成员列表中查找用户
+func findMember(members []*pb.MemberObject, username string) *pb.MemberObject {
+	for _, element := range members {
+		if element.Name == username {
+			return element
+		}
+	}
+	return nil
+}
+
+// delUserGroupsCache 删除用户的群组列表缓存
+func delUserGroupsCache(ctx context.Context, username string) {
+	userID, err := user.QueryUIDByUsername(ctx, username)
+	if err != nil {
+		return
+	}
+	gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:groups:" + fmt.Sprintf("%d", userID)})
+}
+
+// readUserGroupsCache 仅从缓存读取用户的群组列表，未命中时返回 nil
+func readUserGroupsCache(uid int32) *pb.GetGroupsByUIDCache {
+	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:groups:" + fmt.Sprintf("%d", uid)})
+	cacheObj := &pb.GetGroupsByUIDCache{}
+	if err == nil && resp.Result.Code == errorcode.Success && len(resp.Value) > 0 && proto.Unmarshal(resp.Value, cacheObj) == nil {
+		return cacheObj
+	}
+	return nil
+}
+
+// writeUserGroupsCache 将用户的群组列表写入缓存
+func writeUserGroupsCache(uid int32, cacheObj *pb.GetGroupsByUIDCache) {
+	cacheBytes, err := proto.Marshal(cacheObj)
+	if err == nil {
+		go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: "groupuser:groups:" + fmt.Sprintf("%d", uid), Value: cacheBytes})
+	}
+}
+
+// loadUserGroups 读取用户的群组列表缓存，未命中时回源数据库
+func loadUserGroups(ctx context.Context, uid int32) (*pb.GetGroupsByUIDCache, *pb.Result) {
+	if cacheObj := readUserGroupsCache(uid); cacheObj != nil {
+		return cacheObj, nil
+	}
+	username, err := user.QueryUsernameByUID(ctx, uid)
+	if err != nil {
+		return nil, &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)}
+	}
+	// 查询group_user_table获取用户群组
+	cacheObjs, res := queryUserGroups([]string{username})
+	if res != nil {
+		return nil, res
+	}
+	writeUserGroupsCache(uid, cacheObjs[username])
+	return cacheObjs[username], nil
+}
+
+// queryUserGroups 从数据库批量读取用户所在的群组及其置顶、免打扰设置
+func queryUserGroups(usernames []string) (map[string]*pb.GetGroupsByUIDCache, *pb.Result) {
+	placeholders := make([]string, 0, len(usernames))
+	params := make([]*pb_gtw.InterFaceType, 0, len(usernames))
+	for _, username := range usernames {
+		placeholders = append(placeholders, "?")
+		params = append(params, &pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Str{Str: username}})
+	}
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql:    "SELECT username, groupid, is_pinned, is_notify_muted FROM `group_user_table` WHERE username IN (" + strings.Join(placeholders, ", ") + ")",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Params: params,
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return nil, &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)}
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return nil, &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg}
+	}
+	cacheObjs := map[string]*pb.GetGroupsByUIDCache{}
+	for _, username := range usernames {
+		cacheObjs[username] = &pb.GetGroupsByUIDCache{}
+	}
+	for _, row := range sqlResp.Data {
+		if len(row.Result) < 4 {
+			continue
+		}
+		cacheObj, ok := cacheObjs[row.Result[0].GetStr()]
+		if !ok {
+			continue
+		}
+		groupID := row.Result[1].GetInt32()
+		cacheObj.Groups = append(cacheObj.Groups, groupID)
+		if row.Result[2].GetInt32() == 1 {
+			cacheObj.Pinned = append(cacheObj.Pinned, groupID)
+		}
+		if row.Result[3].GetInt32() == 1 {
+			cacheObj.NotifyMuted = append(cacheObj.NotifyMuted, groupID)
+		}
+	}
+	return cacheObjs, nil
+}
+
+// readGroupPublicCache 仅从缓存读取群组公开信息，未命中时返回 nil
+func readGroupPublicCache(groupID int32) *pb.GetGroupPublicInfoCache {
+	resp, err := gateway.ExecRedisBGet(&pb_gtw.RedisGetBytesRequest{DBID: 0, Key: "groupuser:public:" + fmt.Sprintf("%d", groupID)})
+	cacheObj := &pb.GetGroupPublicInfoCache{}
+	if err == nil && resp.Result.Code == errorcode.Success && len(resp.Value) > 0 && proto.Unmarshal(resp.Value, cacheObj) == nil {
+		return cacheObj
+	}
+	return nil
+}
+
+// publicCacheMissTTL 群组不存在时公开信息缓存的过期秒数
+const publicCacheMissTTL = 60
+
+// queryGroupPublicInfos 从数据库批量读取群组公开信息并写入缓存，不存在的群组 Id 为 -1
+func queryGroupPublicInfos(groupIDs []int32) (map[int32]*pb.GetGroupPublicInfoCache, error) {
+	placeholders := make([]string, 0, len(groupIDs))
+	params := make([]*pb_gtw.InterFaceType, 0, len(groupIDs))
+	for _, groupID := range groupIDs {
+		placeholders = append(placeholders, "?")
+		params = append(params, &pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}})
+	}
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT t1.`groupid`, t1.`name`, t1.`create_time`, CAST(t1.`join_policy` AS CHAR), t1.`is_direct_invite`, " +
+			"(SELECT COUNT(*) FROM `group_user_table` AS t2 WHERE t2.`groupid` = t1.`groupid`), " +
+			"IF(COALESCE(t1.`password`, '') = '', 0, 1) " +
+			"FROM `groups` AS t1 WHERE t1.groupid IN (" + strings.Join(placeholders, ", ") + ")",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Params: params,
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return nil, err
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return nil, fmt.Errorf("query group public info failed: %d %s", sqlResp.Result.Code, sqlResp.Result.Msg)
+	}
+	cacheObjs := map[int32]*pb.GetGroupPublicInfoCache{}
+	for _, row := range sqlResp.Data {
+		if len(row.Result) < 7 {
+			continue
+		}
+		groupID := row.Result[0].GetInt32()
+		cacheObjs[groupID] = &pb.GetGroupPublicInfoCache{
+			Id:             groupID,
+			Name:           row.Result[1].GetStr(),
+			CreatedAt:      row.Result[2].GetInt64(),
+			JoinPolicy:     convertSQLJoinPolicyToProto(row.Result[3].GetStr(), row.Result[6].GetInt64() == 1),
+			IsDirectInvite: row.Result[4].GetInt32() == 1,
+			MemberCount:    int32(row.Result[5].GetInt64()),
+		}
+	}
+	for _, groupID := range groupIDs {
+		cacheObj, ok := cacheObjs[groupID]
+		ttl := int32(0)
+		if !ok {
+			// 仅在查询成功且确认不存在时缓存，并设置过期时间
+			cacheObj = &pb.GetGroupPublicInfoCache{Id: -1}
+			cacheObjs[groupID] = cacheObj
+			ttl = publicCacheMissTTL
+		}
+		cacheBytes, err := proto.Marshal(cacheObj)
+		if err == nil {
+			go gateway.ExecRedisBSet(&pb_gtw.RedisSetBytesRequest{DBID: 0, Key: "groupuser:public:" + fmt.Sprintf("%d", groupID), Value: cacheBytes, Ttl: ttl})
+		}
+	}
+	return cacheObjs, nil
+}
+
+// loadGroupPublicCache 读取群组公开信息缓存，群组不存在时 Id 为 -1
+func loadGroupPublicCache(groupID int32) (*pb.GetGroupPublicInfoCache, error) {
+	if cacheObj := readGroupPublicCache(groupID); cacheObj != nil {
+		return cacheObj, nil
+	}
+	cacheObjs, err := queryGroupPublicInfos([]int32{groupID})
+	if err != nil {
+		return nil, err
+	}
+	return cacheObjs[groupID], nil
+}
+
+// loadGroupPasswordHash 读取群组密码哈希，未设置密码时返回空串
+func loadGroupPasswordHash(groupID int32) (string, error) {
+	resp, err := gateway.ExecRedisGet(&pb_gtw.RedisGetStringRequest{DBID: 0, Key: "groupuser:password:" + fmt.Sprintf("%d", groupID)})
+	if err == nil && resp.Result.Code == errorcode.Success && len(resp.Value) > 0 {
+		return resp.Value, nil
+	}
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT `password` FROM `groups` WHERE groupid = ?",
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return "", err
+	}
+	if sqlResp.Result.Code != errorcode.Success || len(sqlResp.Data) == 0 || len(sqlResp.Data[0].Result) == 0 {
+		return "", nil
+	}
+	storedPasswordHash := sqlResp.Data[0].Result[0].GetStr()
+	if storedPasswordHash != "" {
+		go gateway.ExecRedisSet(&pb_gtw.RedisSetStringRequest{DBID: 0, Key: "groupuser:password:" + fmt.Sprintf("%d", groupID), Value: storedPasswordHash})
+	}
+	return storedPasswordHash, nil
+}
+
+// insertGroupMember 以普通成员身份加入群组，记录邀请人与加入方式并清理相关缓存
+func insertGroupMember(groupID int32, username string, uid int32, inviterUID int32, joinMethod pb.JoinMethod) *pb.Result {
+	if res := checkBanned(groupID, uid); res != nil {
+		return res
+	}
+	if res := checkGroupCapacity(groupID); res != nil {
+		return res
+	}
+	// 仅在群组仍存在且用户不在群组中时写入，避免解散后留下孤立成员
+	insertReq := &pb_gtw.SqlRequest{
+		Sql: "INSERT INTO group_user_table (groupid, username, type, joined_at, invited_by_uid, join_method) " +
+			"SELECT t1.`groupid`, ?, 'member', UNIX_TIMESTAMP(), ?, ? FROM `groups` AS t1 WHERE t1.`groupid` = ? " +
+			"AND NOT EXISTS (SELECT 1 FROM `group_user_table` AS t2 WHERE t2.`groupid` = t1.`groupid` AND t2.`username` = ?)",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Str{Str: username}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: inviterUID}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: convertProtoToSQLJoinMethod(joinMethod)}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: username}},
+		},
+		GetRowCount: true,
+	}
+	actorUID := inviterUID
+	if actorUID == 0 {
+		actorUID = uid
+	}
+	ev := newGroupEvent(groupID, pb.GroupEventType_member_joined, actorUID, username, "", convertProtoToSQLJoinMethod(joinMethod))
+	outbox.Attach(insertReq, ev)
+	insertResp, err := gateway.ExecSQL(insertReq)
+	if err != nil {
+		return &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Insert error: %v", err)}
+	}
+	if insertResp.Result.Code != errorcode.Success {
+		return &pb.Result{Code: insertResp.Result.Code, Msg: insertResp.Result.Msg}
+	}
+	if insertResp.RowsAffected == 0 {
+		// 未写入时直接查询数据库区分群组不存在与已在群组中
+		cacheObjs, err := queryGroupPublicInfos([]int32{groupID})
+		if err != nil {
+			return &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)}
+		}
+		if cacheObjs[groupID].Id == -1 {
+			return &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Group not found"}
+		}
+		return &pb.Result{Code: errorcode.GroupUserAlreadyInGroup, Msg: "User already in group"}
+	}
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:groups:" + fmt.Sprintf("%d", uid)})
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:info:" + fmt.Sprintf("%d", groupID)})
+	go gateway.ExecRedisDel(&pb_gtw.RedisDelRequest{DBID: 0, Key: "groupuser:public:" + fmt.Sprintf("%d", groupID)})
+	event.Publish(ev)
+	return &pb.Result{Code: errorcode.Success, Msg: ""}
+}
+
+// countGroupMembers 统计群组成员数
+func countGroupMembers(groupID int32) (int32, *pb.Result) {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT COUNT(*) FROM `group_user_table` WHERE `groupid` = ?",
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: groupID}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return 0, &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)}
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return 0, &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg}
+	}
+	if len(sqlResp.Data) == 0 || len(sqlResp.Data[0].Result) == 0 {
+		return 0, nil
+	}
+	return int32(sqlResp.Data[0].Result[0].GetInt64()), nil
+}
+
+// checkGroupCapacity 检查群组人数是否已达上限
+func checkGroupCapacity(groupID int32) *pb.Result {
+	maxMembers := config.LatestConfig.Group.MaxMembers
+	if maxMembers <= 0 {
+		return nil
+	}
+	count, res := countGroupMembers(groupID)
+	if res != nil {
+		return res
+	}
+	if int(count) >= maxMembers {
+		return &pb.Result{Code: errorcode.GroupUserGroupFull, Msg: "Group is full"}
+	}
+	return nil
+}
+
+// normalizeLimit 规范分页大小
+func normalizeLimit(limit int32) int32 {
+	if limit <= 0 {
+		return defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		return maxPageLimit
+	}
+	return limit
+}
+
+// boolToInt32 将布尔值转为数据库中的 0 或 1
+func boolToInt32(value bool) int32 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,129 @@
+package grpc
+
+import (
+	"fmt"
+
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/event"
+	"StealthIMGroupUser/user"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newGroupEvent 构造群组事件，序号与时间在发布时填写
+func newGroupEvent(groupID int32, eventType pb.GroupEventType, actorUID int32, username string, oldValue string, newValue string) *pb.GroupEvent {
+	return &pb.GroupEvent{
+		GroupId:  groupID,
+		Type:     eventType,
+		ActorUid: actorUID,
+		Username: username,
+		OldValue: oldValue,
+		NewValue: newValue,
+	}
+}
+
+// watchFilter 记录订阅者关注的群组，并随订阅者自身的加入与离开更新
+type watchFilter struct {
+	username  string
+	followNew bool
+	groups    map[int32]bool
+}
+
+// accept 判断事件是否推送给订阅者
+func (f *watchFilter) accept(ev *pb.GroupEvent) bool {
+	self := ev.Username == f.username
+	switch {
+	case ev.Type == pb.GroupEventType_member_joined && self && f.followNew:
+		f.groups[ev.GroupId] = true
+		return true
+	case !f.groups[ev.GroupId]:
+		return false
+	case ev.Type == pb.GroupEventType_group_dissolved,
+		self && (ev.Type == pb.GroupEventType_member_left || ev.Type == pb.GroupEventType_member_kicked):
+		// 推送最后一条事件后不再关注该群组
+		delete(f.groups, ev.GroupId)
+	}
+	return true
+}
+
+// newWatchFilter 按请求确定关注的群组，未指定群组时关注用户所在的全部群组
+func newWatchFilter(req *pb.WatchGroupEventsRequest, stream pb.StealthIMGroupUser_WatchGroupEventsServer) (*watchFilter, *pb.Result) {
+	ctx := stream.Context()
+	username, err := user.QueryUsernameByUID(ctx, req.Uid)
+	if err != nil {
+		return nil, &pb.Result{Code: errorcode.GroupUserQueryError, Msg: fmt.Sprintf("User query error: %v", err)}
+	}
+	filter := &watchFilter{username: username, followNew: len(req.GroupIds) == 0, groups: map[int32]bool{}}
+	if filter.followNew {
+		cacheObj, res := loadUserGroups(ctx, req.Uid)
+		if res != nil {
+			return nil, res
+		}
+		for _, groupID := range cacheObj.Groups {
+			filter.groups[groupID] = true
+		}
+		return filter, nil
+	}
+	if len(req.GroupIds) > int(maxPageLimit) {
+		return nil, &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Too many groups"}
+	}
+	for _, groupID := range req.GroupIds {
+		member, err := loadMemberCache(groupID, username)
+		if err != nil {
+			return nil, &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)}
+		}
+		if member == nil {
+			return nil, &pb.Result{Code: errorcode.GroupUserPermissionDenied, Msg: "Permission denied"}
+		}
+		filter.groups[groupID] = true
+	}
+	return filter, nil
+}
+
+// WatchGroupEvents 推送群组成员与资料变化事件，可从上次收到的序号续传
+// 事件只在本实例内广播，序号也只在本实例有效；多实例部署时客户端需固定连接同一实例，跨实例的可靠投递使用回调
+func (s *server) WatchGroupEvents(req *pb.WatchGroupEventsRequest, stream pb.StealthIMGroupUser_WatchGroupEventsServer) error {
+	filter, res := newWatchFilter(req, stream)
+	if res != nil {
+		return stream.Send(&pb.WatchGroupEventsResponse{Result: res})
+	}
+	subID, ch, backlog, ok := event.Subscribe(req.FromSeq)
+	if !ok {
+		return stream.Send(&pb.WatchGroupEventsResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserEventsExpired, Msg: "Events expired"},
+		})
+	}
+	defer event.Unsubscribe(subID)
+
+	// lastSeq 已处理的最后序号，被过滤的事件同样计入，断开时供客户端续传
+	lastSeq := req.FromSeq
+	for _, ev := range backlog {
+		lastSeq = ev.Seq
+		if !filter.accept(ev) {
+			continue
+		}
+		if err := stream.Send(&pb.WatchGroupEventsResponse{Result: &pb.Result{Code: errorcode.Success}, Event: ev}); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-ch:
+			// 订阅因消费过慢被断开，客户端应按返回的序号重新订阅
+			if !ok {
+				return status.Errorf(codes.Aborted, "subscriber too slow, resume from seq %d", lastSeq)
+			}
+			lastSeq = ev.Seq
+			if !filter.accept(ev) {
+				continue
+			}
+			if err := stream.Send(&pb.WatchGroupEventsResponse{Result: &pb.Result{Code: errorcode.Success}, Event: ev}); err != nil {
+				return err
+			}
+		}
+	}
+}
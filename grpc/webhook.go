@@ -0,0 +1,207 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+	"StealthIMGroupUser/webhook"
+)
+
+const (
+	// maxWebhooks 单个群组最多注册的回调数
+	maxWebhooks = 10
+	// maxWebhookURLLength 回调地址最大长度
+	maxWebhookURLLength = 512
+	// maxWebhookSecretLength 签名密钥最大长度
+	maxWebhookSecretLength = 128
+)
+
+// validWebhookURL 检查回调地址，仅允许 http 与 https
+// 字面量地址在注册时即按投递规则检查，域名在投递连接时检查解析结果
+func validWebhookURL(rawURL string) bool {
+	if len(rawURL) == 0 || len(rawURL) > maxWebhookURLLength {
+		return false
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" || parsed.User != nil {
+		return false
+	}
+	if addr, err := netip.ParseAddr(parsed.Hostname()); err == nil {
+		return webhook.AllowedAddr(addr)
+	}
+	if strings.EqualFold(parsed.Hostname(), "localhost") {
+		return webhook.AllowedAddr(netip.IPv6Loopback())
+	}
+	return true
+}
+
+// encodeWebhookEvents 将订阅的事件类型编码为逗号分隔的名称，空字符串表示全部事件
+func encodeWebhookEvents(events []pb.GroupEventType) (string, bool) {
+	var names []string
+	for _, eventType := range events {
+		name, ok := pb.GroupEventType_name[int32(eventType)]
+		if !ok {
+			return "", false
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ","), true
+}
+
+// decodeWebhookEvents 解析数据库中的事件类型列表
+func decodeWebhookEvents(value string) []pb.GroupEventType {
+	var events []pb.GroupEventType
+	for _, name := range strings.Split(value, ",") {
+		if eventType, ok := pb.GroupEventType_value[name]; ok {
+			events = append(events, pb.GroupEventType(eventType))
+		}
+	}
+	return events
+}
+
+// RegisterWebhook 注册群组事件回调，仅群主可用
+func (s *server) RegisterWebhook(ctx context.Context, req *pb.RegisterWebhookRequest) (*pb.RegisterWebhookResponse, error) {
+	if !validWebhookURL(req.Url) {
+		return &pb.RegisterWebhookResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Invalid webhook url"},
+		}, nil
+	}
+	if len(req.Secret) == 0 || len(req.Secret) > maxWebhookSecretLength {
+		return &pb.RegisterWebhookResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Invalid webhook secret"},
+		}, nil
+	}
+	events, ok := encodeWebhookEvents(req.Events)
+	if !ok {
+		return &pb.RegisterWebhookResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Invalid event type"},
+		}, nil
+	}
+
+	// 回调数量达到上限时拒绝注册
+	insertReq := &pb_gtw.SqlRequest{
+		Sql: "INSERT INTO `group_webhook` (`groupid`, `url`, `events`, `secret`, `creator_uid`) " +
+			"SELECT ?, ?, ?, ?, ? FROM DUAL WHERE (SELECT COUNT(*) FROM `group_webhook` WHERE `groupid` = ?) < ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: req.Url}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: events}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: req.Secret}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.Uid}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: maxWebhooks}},
+		},
+		GetRowCount:     true,
+		GetLastInsertId: true,
+	}
+	insertResp, err := gateway.ExecSQL(insertReq)
+	if err != nil {
+		return &pb.RegisterWebhookResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Insert error: %v", err)},
+		}, nil
+	}
+	if insertResp.Result.Code != errorcode.Success {
+		return &pb.RegisterWebhookResponse{
+			Result: &pb.Result{Code: insertResp.Result.Code, Msg: insertResp.Result.Msg},
+		}, nil
+	}
+	if insertResp.RowsAffected == 0 {
+		return &pb.RegisterWebhookResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserInvalidArgument, Msg: "Too many webhooks"},
+		}, nil
+	}
+	appendAuditLog(req.GroupId, req.Uid, pb.AuditAction_register_webhook, req.Url, "", events)
+	return &pb.RegisterWebhookResponse{
+		Result:    &pb.Result{Code: errorcode.Success, Msg: ""},
+		WebhookId: insertResp.LastInsertId,
+	}, nil
+}
+
+// ListWebhooks 获取群组已注册的回调，不返回签名密钥
+func (s *server) ListWebhooks(ctx context.Context, req *pb.ListWebhooksRequest) (*pb.ListWebhooksResponse, error) {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT `id`, `url`, `events`, `creator_uid`, `create_time` FROM `group_webhook` WHERE `groupid` = ? ORDER BY `id` LIMIT ?",
+		Db:  pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: maxWebhooks}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return &pb.ListWebhooksResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Database error: %v", err)},
+		}, nil
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return &pb.ListWebhooksResponse{
+			Result: &pb.Result{Code: sqlResp.Result.Code, Msg: sqlResp.Result.Msg},
+		}, nil
+	}
+	var webhooks []*pb.WebhookObject
+	for _, row := range sqlResp.Data {
+		if len(row.Result) < 5 {
+			continue
+		}
+		webhooks = append(webhooks, &pb.WebhookObject{
+			Id:         row.Result[0].GetInt64(),
+			GroupId:    req.GroupId,
+			Url:        row.Result[1].GetStr(),
+			Events:     decodeWebhookEvents(row.Result[2].GetStr()),
+			CreatorUid: row.Result[3].GetInt32(),
+			CreatedAt:  row.Result[4].GetInt64(),
+		})
+	}
+	return &pb.ListWebhooksResponse{
+		Result:   &pb.Result{Code: errorcode.Success, Msg: ""},
+		Webhooks: webhooks,
+	}, nil
+}
+
+// DeleteWebhook 删除群组回调，未投递的事件随之作废
+func (s *server) DeleteWebhook(ctx context.Context, req *pb.DeleteWebhookRequest) (*pb.DeleteWebhookResponse, error) {
+	deleteReq := &pb_gtw.SqlRequest{
+		// 同一语句删除回调及其投递记录，避免残留记录被调度器扫描
+		Sql: "DELETE t1, t2 FROM `group_webhook` AS t1 LEFT JOIN `group_webhook_delivery` AS t2 ON t2.`webhook_id` = t1.`id` " +
+			"WHERE t1.`id` = ? AND t1.`groupid` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: req.WebhookId}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: req.GroupId}},
+		},
+		GetRowCount: true,
+	}
+	deleteResp, err := gateway.ExecSQL(deleteReq)
+	if err != nil {
+		return &pb.DeleteWebhookResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserDatabaseError, Msg: fmt.Sprintf("Delete error: %v", err)},
+		}, nil
+	}
+	if deleteResp.Result.Code != errorcode.Success {
+		return &pb.DeleteWebhookResponse{
+			Result: &pb.Result{Code: deleteResp.Result.Code, Msg: deleteResp.Result.Msg},
+		}, nil
+	}
+	if deleteResp.RowsAffected == 0 {
+		return &pb.DeleteWebhookResponse{
+			Result: &pb.Result{Code: errorcode.GroupUserNotFound, Msg: "Webhook not found"},
+		}, nil
+	}
+	appendAuditLog(req.GroupId, req.Uid, pb.AuditAction_delete_webhook, fmt.Sprintf("%d", req.WebhookId), "", "")
+	return &pb.DeleteWebhookResponse{
+		Result: &pb.Result{Code: errorcode.Success, Msg: ""},
+	}, nil
+}
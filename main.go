@@ -4,7 +4,10 @@ import (
 	"StealthIMGroupUser/config"
 	"StealthIMGroupUser/gateway"
 	"StealthIMGroupUser/grpc"
+	"StealthIMGroupUser/outbox"
+	"StealthIMGroupUser/session"
 	"StealthIMGroupUser/user"
+	"StealthIMGroupUser/webhook"
 	"log"
 )
 
@@ -26,10 +29,17 @@ func main() {
 	log.Printf("    Host: %s\n", cfg.Session.Host)
 	log.Printf("    Port: %d\n", cfg.Session.Port)
 	log.Printf("    ConnNum: %d\n", cfg.Session.ConnNum)
+	log.Printf("    Auth: %v\n", cfg.Session.Auth)
 
 	// 启动 DBGateway
 	go gateway.InitConns()
 	go user.InitConns()
+	go session.InitConns()
+	if cfg.Webhook.Enable {
+		outbox.RegisterSink(&webhook.Sink{})
+	}
+	go outbox.Start()
+	go webhook.Start()
 
 	// 启动 GRPC 服务
 	grpc.Start(cfg)
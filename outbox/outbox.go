@@ -0,0 +1,210 @@
+package outbox
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/config"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+)
+
+const (
+	// leaseSeconds 投递期间占用事件的时长，超时后可被其它实例重新投递
+	leaseSeconds = 60
+	// maxBackoffSeconds 重试间隔上限
+	maxBackoffSeconds = 3600
+	// maxErrorLength 记录的错误信息最大长度
+	maxErrorLength = 255
+)
+
+// Attach 在同一次数据库请求中追加发件箱事件，仅当前一条语句影响了行时写入
+// 网关需在同一事务中执行整个请求，使事件与成员变化同时提交或回滚；未启用发件箱时不写入，避免事件无人投递而堆积
+func Attach(sqlReq *pb_gtw.SqlRequest, ev *pb.GroupEvent) {
+	if !config.LatestConfig.Outbox.Enable {
+		return
+	}
+	sqlReq.Sql += "; INSERT INTO `group_outbox` (`groupid`, `event_type`, `actor_uid`, `username`, `old_value`, `new_value`, `next_attempt_time`) " +
+		"SELECT ?, ?, ?, ?, ?, ?, UNIX_TIMESTAMP() FROM DUAL WHERE ROW_COUNT() > 0"
+	sqlReq.Params = append(sqlReq.Params,
+		&pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Int32{Int32: ev.GroupId}},
+		&pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Str{Str: ev.Type.String()}},
+		&pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Int32{Int32: ev.ActorUid}},
+		&pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Str{Str: ev.Username}},
+		&pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Str{Str: ev.OldValue}},
+		&pb_gtw.InterFaceType{Response: &pb_gtw.InterFaceType_Str{Str: ev.NewValue}},
+	)
+}
+
+// extraSinks 由其它模块注册的投递目标
+var extraSinks []Sink
+
+// RegisterSink 注册额外的投递目标，需在 Start 之前调用
+func RegisterSink(sink Sink) {
+	extraSinks = append(extraSinks, sink)
+}
+
+// pendingEvent 待投递的事件及其投递状态
+type pendingEvent struct {
+	event           *pb.GroupEvent
+	attempts        int32
+	nextAttemptTime int64
+}
+
+// queryPending 读取已到重试时间的待投递事件
+func queryPending(limit int32) ([]*pendingEvent, error) {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT `id`, `groupid`, `event_type`, `actor_uid`, `username`, `old_value`, `new_value`, `create_time`, `attempts`, `next_attempt_time` " +
+			"FROM `group_outbox` WHERE `status` = 'pending' AND `next_attempt_time` <= UNIX_TIMESTAMP() ORDER BY `id` LIMIT ?",
+		Db: pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: limit}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return nil, err
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return nil, fmt.Errorf("[%d]%s", sqlResp.Result.Code, sqlResp.Result.Msg)
+	}
+	var pending []*pendingEvent
+	for _, row := range sqlResp.Data {
+		if len(row.Result) < 10 {
+			continue
+		}
+		pending = append(pending, &pendingEvent{
+			event: &pb.GroupEvent{
+				Seq:       row.Result[0].GetInt64(),
+				GroupId:   row.Result[1].GetInt32(),
+				Type:      pb.GroupEventType(pb.GroupEventType_value[row.Result[2].GetStr()]),
+				ActorUid:  row.Result[3].GetInt32(),
+				Username:  row.Result[4].GetStr(),
+				OldValue:  row.Result[5].GetStr(),
+				NewValue:  row.Result[6].GetStr(),
+				Timestamp: row.Result[7].GetInt64(),
+			},
+			attempts:        row.Result[8].GetInt32(),
+			nextAttemptTime: row.Result[9].GetInt64(),
+		})
+	}
+	return pending, nil
+}
+
+// claim 占用事件，避免多个实例重复投递
+func claim(pending *pendingEvent) bool {
+	updateReq := &pb_gtw.SqlRequest{
+		Sql:    "UPDATE `group_outbox` SET `next_attempt_time` = UNIX_TIMESTAMP() + ? WHERE `id` = ? AND `status` = 'pending' AND `next_attempt_time` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: leaseSeconds}},
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: pending.event.Seq}},
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: pending.nextAttemptTime}},
+		},
+		GetRowCount: true,
+	}
+	updateResp, err := gateway.ExecSQL(updateReq)
+	return err == nil && updateResp.Result.Code == errorcode.Success && updateResp.RowsAffected > 0
+}
+
+// Backoff 返回第 attempts 次失败后的重试间隔
+func Backoff(attempts int32) int64 {
+	if attempts >= 12 {
+		return maxBackoffSeconds
+	}
+	return min(int64(1)<<attempts, maxBackoffSeconds)
+}
+
+// markResult 记录一次投递结果
+func markResult(pending *pendingEvent, deliverErr error) {
+	attempts := pending.attempts + 1
+	var updateReq *pb_gtw.SqlRequest
+	if deliverErr == nil {
+		updateReq = &pb_gtw.SqlRequest{
+			Sql: "UPDATE `group_outbox` SET `status` = 'delivered', `attempts` = ?, `delivered_time` = UNIX_TIMESTAMP(), `last_error` = '' WHERE `id` = ?",
+			Params: []*pb_gtw.InterFaceType{
+				{Response: &pb_gtw.InterFaceType_Int32{Int32: attempts}},
+				{Response: &pb_gtw.InterFaceType_Int64{Int64: pending.event.Seq}},
+			},
+		}
+	} else {
+		status := "pending"
+		if maxAttempts := config.LatestConfig.Outbox.MaxAttempts; maxAttempts > 0 && int(attempts) >= maxAttempts {
+			status = "failed"
+		}
+		lastError := deliverErr.Error()
+		if utf8.RuneCountInString(lastError) > maxErrorLength {
+			lastError = string([]rune(lastError)[:maxErrorLength])
+		}
+		updateReq = &pb_gtw.SqlRequest{
+			Sql: "UPDATE `group_outbox` SET `status` = ?, `attempts` = ?, `next_attempt_time` = UNIX_TIMESTAMP() + ?, `last_error` = ? WHERE `id` = ?",
+			Params: []*pb_gtw.InterFaceType{
+				{Response: &pb_gtw.InterFaceType_Str{Str: status}},
+				{Response: &pb_gtw.InterFaceType_Int32{Int32: attempts}},
+				{Response: &pb_gtw.InterFaceType_Int64{Int64: Backoff(attempts)}},
+				{Response: &pb_gtw.InterFaceType_Str{Str: lastError}},
+				{Response: &pb_gtw.InterFaceType_Int64{Int64: pending.event.Seq}},
+			},
+		}
+	}
+	updateReq.Db = pb_gtw.SqlDatabases_Groups
+	updateReq.Commit = true
+	updateResp, err := gateway.ExecSQL(updateReq)
+	if err != nil {
+		log.Printf("[OUTBOX]Update event %d error: %v\n", pending.event.Seq, err)
+	} else if updateResp.Result.Code != errorcode.Success {
+		log.Printf("[OUTBOX]Update event %d error: [%d]%s\n", pending.event.Seq, updateResp.Result.Code, updateResp.Result.Msg)
+	}
+}
+
+// relay 投递一批待发送事件
+func relay(sinks []Sink) {
+	batchSize := int32(config.LatestConfig.Outbox.BatchSize)
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	pending, err := queryPending(batchSize)
+	if err != nil {
+		log.Printf("[OUTBOX]Query error: %v\n", err)
+		return
+	}
+	for _, element := range pending {
+		if !claim(element) {
+			continue
+		}
+		var deliverErr error
+		for _, sink := range sinks {
+			if err := sink.Deliver(element.event); err != nil {
+				deliverErr = fmt.Errorf("%s: %v", sink.Name(), err)
+				break
+			}
+		}
+		markResult(element, deliverErr)
+	}
+}
+
+// Start 启动发件箱投递循环，未配置任何投递目标时直接返回
+func Start() {
+	if !config.LatestConfig.Outbox.Enable {
+		return
+	}
+	sinks := append(buildSinks(), extraSinks...)
+	if len(sinks) == 0 {
+		log.Printf("[OUTBOX]No sink configured\n")
+		return
+	}
+	log.Printf("[OUTBOX]Start relay with %d sinks\n", len(sinks))
+	for {
+		interval := config.LatestConfig.Outbox.Interval
+		if interval <= 0 {
+			interval = 1000
+		}
+		time.Sleep(time.Duration(interval) * time.Millisecond)
+		relay(sinks)
+	}
+}
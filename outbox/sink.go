@@ -0,0 +1,126 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/cert"
+	"StealthIMGroupUser/config"
+	"StealthIMGroupUser/errorcode"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// deliverTimeout 单次投递超时
+const deliverTimeout = 5 * time.Second
+
+// Sink 事件投递目标，同一事件可能被重复投递，接收方应按 Seq 去重
+type Sink interface {
+	Name() string
+	Deliver(ev *pb.GroupEvent) error
+}
+
+// grpcSink 通过 gRPC 回调投递
+type grpcSink struct {
+	conn *grpc.ClientConn
+}
+
+func (s *grpcSink) Name() string {
+	return "grpc"
+}
+
+func (s *grpcSink) Deliver(ev *pb.GroupEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), deliverTimeout)
+	defer cancel()
+	resp, err := pb.NewStealthIMGroupUserEventSinkClient(s.conn).OnGroupEvent(ctx, ev)
+	if err != nil {
+		return err
+	}
+	if resp.Result.Code != errorcode.Success {
+		return fmt.Errorf("[%d]%s", resp.Result.Code, resp.Result.Msg)
+	}
+	return nil
+}
+
+// httpSink 通过 HTTP POST 投递 JSON
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) Name() string {
+	return "http"
+}
+
+func (s *httpSink) Deliver(ev *pb.GroupEvent) error {
+	body, err := protojson.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// fileSink 以每行一个 JSON 的形式追加写入文件
+type fileSink struct {
+	path string
+	lock sync.Mutex
+}
+
+func (s *fileSink) Name() string {
+	return "file"
+}
+
+func (s *fileSink) Deliver(ev *pb.GroupEvent) error {
+	line, err := protojson.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// buildSinks 按配置创建投递目标
+func buildSinks() []Sink {
+	cfg := config.LatestConfig.Outbox
+	var sinks []Sink
+	if cfg.GRPCTarget != "" {
+		creds, err := cert.ClientCredentials(cfg.TLS)
+		if err != nil {
+			log.Printf("[OUTBOX]Connect %s TLS error: %v\n", cfg.GRPCTarget, err)
+		} else if conn, err := grpc.NewClient(cfg.GRPCTarget, grpc.WithTransportCredentials(creds)); err != nil {
+			log.Printf("[OUTBOX]Connect %s error: %v\n", cfg.GRPCTarget, err)
+		} else {
+			sinks = append(sinks, &grpcSink{conn: conn})
+		}
+	}
+	if cfg.WebhookURL != "" {
+		sinks = append(sinks, &httpSink{url: cfg.WebhookURL, client: &http.Client{Timeout: deliverTimeout}})
+	}
+	if cfg.LogFile != "" {
+		sinks = append(sinks, &fileSink{path: cfg.LogFile})
+	}
+	return sinks
+}
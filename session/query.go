@@ -0,0 +1,34 @@
+package session
+
+import (
+	pb "StealthIMGroupUser/StealthIM.Session"
+	"StealthIMGroupUser/config"
+	"StealthIMGroupUser/errorcode"
+	"context"
+	"fmt"
+	"time"
+)
+
+// QueryUIDBySession 通过会话查询已登录用户的 uid
+func QueryUIDBySession(ctx context.Context, session string) (int32, error) {
+	mainlock.RLock()
+	defer mainlock.RUnlock()
+	conn, err := chooseConn()
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.LatestConfig.Session.Timeout)*time.Millisecond)
+	defer cancel()
+	c := pb.NewStealthIMSessionClient(conn)
+	res, err2 := c.Get(ctx, &pb.GetRequest{Session: session})
+	if err2 != nil {
+		return 0, err2
+	}
+	if res.Result.Code != errorcode.Success {
+		return 0, fmt.Errorf("[%d]%s", res.Result.Code, res.Result.Msg)
+	}
+	if res.Uid == 0 {
+		return 0, fmt.Errorf("session not found")
+	}
+	return res.Uid, nil
+}
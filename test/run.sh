@@ -5,6 +5,7 @@ mkdir -p ./test_cache/db
 mkdir -p ./test_cache/session
 mkdir -p ./test_cache/user
 mkdir -p ./test_cache/groupuser
+mkdir -p ./test_cache/groupuser_auth
 
 NOWPWD=$(pwd)
 
@@ -92,6 +93,27 @@ sql_timeout = 5000 # 单位：ms
 
 [security]
 password_salt = "<stim_you_salt>"
+
+[outbox]
+enable = true
+interval = 200
+
+[webhook]
+enable = true
+interval = 200
+allow_networks = ["127.0.0.1/32"]
+EOF
+
+# 启用会话校验的实例，其余配置与上面相同
+sed -e 's/^port = 50058 /port = 50059 /' "${NOWPWD}/test_cache/groupuser/config.toml" > "${NOWPWD}/test_cache/groupuser_auth/config.toml"
+cat <<EOF >> "${NOWPWD}/test_cache/groupuser_auth/config.toml"
+
+[session]
+host = "127.0.0.1"
+port = 50054
+conn_num = 5
+timeout = 5000
+auth = true
 EOF
 
 wget https://github.com/StealthIM/StealthIMDB/releases/latest/download/StealthIMDB -O ./test_cache/db/StealthIMDB
@@ -103,6 +125,8 @@ chmod +x ./test_cache/user/StealthIMUser
 
 cp ../bin/StealthIMGroupUser ./test_cache/groupuser/StealthIMGroupUser
 chmod +x ./test_cache/groupuser/StealthIMGroupUser
+cp ../bin/StealthIMGroupUser ./test_cache/groupuser_auth/StealthIMGroupUser
+chmod +x ./test_cache/groupuser_auth/StealthIMGroupUser
 
 echo "Start DB"
 cd ${NOWPWD}/test_cache/db && ./StealthIMDB --config=${NOWPWD}/test_cache/db/config.toml > ${NOWPWD}/test_cache/db.log 2>&1 &
@@ -121,6 +145,7 @@ sleep 3s
 
 echo "Start GroupUser"
 cd ${NOWPWD}/test_cache/groupuser && ./StealthIMGroupUser --config=${NOWPWD}/test_cache/groupuser/config.toml > ${NOWPWD}/test_cache/groupuser.log 2>&1 &
+cd ${NOWPWD}/test_cache/groupuser_auth && ./StealthIMGroupUser --config=${NOWPWD}/test_cache/groupuser_auth/config.toml > ${NOWPWD}/test_cache/groupuser_auth.log 2>&1 &
 
 sleep 15s
 echo "Start Test"
@@ -150,6 +175,10 @@ echo "::group::GroupUser Log"
 cat ${NOWPWD}/test_cache/groupuser.log
 echo "::endgroup::"
 
+echo "::group::GroupUser Auth Log"
+cat ${NOWPWD}/test_cache/groupuser_auth.log
+echo "::endgroup::"
+
 if [ "$RETVAL" -ne 0 ]; then
     echo "::error title=Test failed::Test Log: ${NOWPWD}/test_cache/test.log"
     while IFS= read -r line
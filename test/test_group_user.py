@@ -3,14 +3,25 @@ import asyncio
 import pytest_asyncio
 import time
 from grpclib.client import Channel
+from grpclib.const import Cardinality
+from grpclib.exceptions import GRPCError
 import groupuser_pb2
 from groupuser_grpc import StealthIMGroupUserStub
 import user_pb2
 from user_grpc import StealthIMUserStub
+import db_gateway_pb2
+from db_gateway_grpc import StealthIMDBGatewayStub
 import random
+import hmac
+import hashlib
+import json
 
 username_perfix = str(random.randint(100000, 999999))
 
+# 与 errorcode 中的定义一致
+CODE_PERMISSION_DENIED = 1403
+CODE_UNAUTHENTICATED = 1416
+
 
 async def register_user(stub: StealthIMUserStub, username: str, password="password"):
     response = await stub.Register(user_pb2.RegisterRequest(
@@ -63,6 +74,34 @@ async def group_user_channel():
         "Failed to connect to GroupUser service after 3 attempts")
 
 
+@pytest_asyncio.fixture()
+async def group_user_auth_channel():
+    # 启用会话校验的实例
+    for _ in range(3):
+        try:
+            async with Channel("127.0.0.1", 50059) as channel:
+                yield channel
+                return
+        except ConnectionRefusedError:
+            time.sleep(1)  # 等待1秒后重试
+    raise ConnectionError(
+        "Failed to connect to GroupUser auth service after 3 attempts")
+
+
+@pytest_asyncio.fixture()
+async def db_gateway_channel():
+    # 添加重试机制
+    for _ in range(3):
+        try:
+            async with Channel("127.0.0.1", 50051) as channel:
+                yield channel
+                return
+        except ConnectionRefusedError:
+            time.sleep(1)  # 等待1秒后重试
+    raise ConnectionError(
+        "Failed to connect to DBGateway service after 3 attempts")
+
+
 @pytest_asyncio.fixture()
 async def user_stub(user_channel):
     return StealthIMUserStub(user_channel)
@@ -72,6 +111,16 @@ async def user_stub(user_channel):
 async def group_user_stub(group_user_channel):
     return StealthIMGroupUserStub(group_user_channel)
 
+
+@pytest_asyncio.fixture()
+async def db_gateway_stub(db_gateway_channel):
+    return StealthIMDBGatewayStub(db_gateway_channel)
+
+
+@pytest_asyncio.fixture()
+async def group_user_auth_stub(group_user_auth_channel):
+    return StealthIMGroupUserStub(group_user_auth_channel)
+
 user_created = False
 
 
@@ -271,7 +320,8 @@ async def test_group_invite(group_user_stub: StealthIMGroupUserStub, user_lst: l
     # 创建群组
     create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
         name="grp5",
-        uid=user_lst[0]
+        uid=user_lst[0],
+        is_direct_invite=True
     ))
     assert create_resp.result.code == 800
     group_id = create_resp.group_id
@@ -329,7 +379,8 @@ async def test_group_settype(group_user_stub: StealthIMGroupUserStub, user_lst:
     # 创建群组
     create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
         name="grp6",
-        uid=user_lst[0]
+        uid=user_lst[0],
+        is_direct_invite=True
     ))
     assert create_resp.result.code == 800
     group_id = create_resp.group_id
@@ -399,7 +450,8 @@ async def test_group_changename(group_user_stub: StealthIMGroupUserStub, user_ls
     # 创建群组
     create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
         name="grp7",
-        uid=user_lst[0]
+        uid=user_lst[0],
+        is_direct_invite=True
     ))
     assert create_resp.result.code == 800
     group_id = create_resp.group_id
@@ -521,7 +573,8 @@ async def test_group_kickuser(group_user_stub: StealthIMGroupUserStub, user_lst:
     # 创建群组
     create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
         name="grp9",
-        uid=user_lst[0]
+        uid=user_lst[0],
+        is_direct_invite=True
     ))
     assert create_resp.result.code == 800
     group_id = create_resp.group_id
@@ -619,7 +672,8 @@ async def test_group_leave(group_user_stub: StealthIMGroupUserStub, user_lst: li
     # 创建群组
     create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
         name="grp10",
-        uid=user_lst[0]
+        uid=user_lst[0],
+        is_direct_invite=True
     ))
     assert create_resp.result.code == 800
     group_id = create_resp.group_id
@@ -696,4 +750,1937 @@ async def test_group_leave(group_user_stub: StealthIMGroupUserStub, user_lst: li
         uid=user_lst[0],
         username=username_perfix+"_acc1"
     ))
+    assert kick_resp.result.code != 800
+
+
+@pytest.mark.asyncio
+async def test_group_dissolve(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp11",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert join_resp.result.code == 800
+
+    grps_resp = await group_user_stub.GetGroupsByUID(groupuser_pb2.GetGroupsByUIDRequest(
+        uid=user_lst[1]
+    ))
+    assert grps_resp.result.code == 800
+    assert group_id in grps_resp.groups
+
+    link_resp = await group_user_stub.CreateInviteLink(groupuser_pb2.CreateInviteLinkRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        max_uses=1
+    ))
+    assert link_resp.result.code == 800
+    token = link_resp.token
+
+    dissolve_resp = await group_user_stub.DissolveGroup(groupuser_pb2.DissolveGroupRequest(
+        group_id=group_id,
+        uid=user_lst[1]
+    ))
+    assert dissolve_resp.result.code != 800
+
+    dissolve_resp = await group_user_stub.DissolveGroup(groupuser_pb2.DissolveGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert dissolve_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    pinfo_resp = await group_user_stub.GetGroupPublicInfo(groupuser_pb2.GetGroupPublicInfoRequest(
+        group_id=group_id,
+    ))
+    assert pinfo_resp.result.code != 800
+
+    info_resp = await group_user_stub.GetGroupInfo(groupuser_pb2.GetGroupInfoRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert info_resp.result.code != 800
+
+    grps_resp = await group_user_stub.GetGroupsByUID(groupuser_pb2.GetGroupsByUIDRequest(
+        uid=user_lst[1]
+    ))
+    assert grps_resp.result.code == 800
+    assert group_id not in grps_resp.groups
+
+    # 解散后邀请链接失效，不能再加入
+    redeem_resp = await group_user_stub.RedeemInviteLink(groupuser_pb2.RedeemInviteLinkRequest(
+        token=token,
+        uid=user_lst[2]
+    ))
+    assert redeem_resp.result.code != 800
+
+    grps_resp = await group_user_stub.GetGroupsByUID(groupuser_pb2.GetGroupsByUIDRequest(
+        uid=user_lst[2]
+    ))
+    assert grps_resp.result.code == 800
+    assert group_id not in grps_resp.groups
+
+
+@pytest.mark.asyncio
+async def test_group_transfer_owner(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp12",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert join_resp.result.code == 800
+
+    set_resp = await group_user_stub.SetUserType(groupuser_pb2.SetUserTypeRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2",
+        type=groupuser_pb2.MemberType.owner,
+    ))
+    assert set_resp.result.code != 800
+
+    set_resp = await group_user_stub.SetUserType(groupuser_pb2.SetUserTypeRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc1",
+        type=groupuser_pb2.MemberType.member,
+    ))
+    assert set_resp.result.code != 800
+
+    transfer_resp = await group_user_stub.TransferOwnership(groupuser_pb2.TransferOwnershipRequest(
+        group_id=group_id,
+        from_uid=user_lst[1],
+        to_username=username_perfix+"_acc1"
+    ))
+    assert transfer_resp.result.code != 800
+
+    transfer_resp = await group_user_stub.TransferOwnership(groupuser_pb2.TransferOwnershipRequest(
+        group_id=group_id,
+        from_uid=user_lst[0],
+        to_username=username_perfix+"_acc3"
+    ))
+    assert transfer_resp.result.code != 800
+
+    transfer_resp = await group_user_stub.TransferOwnership(groupuser_pb2.TransferOwnershipRequest(
+        group_id=group_id,
+        from_uid=user_lst[0],
+        to_username=username_perfix+"_acc2"
+    ))
+    assert transfer_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    info_resp = await group_user_stub.GetGroupInfo(groupuser_pb2.GetGroupInfoRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert info_resp.result.code == 800
+    assert (username_perfix+"_acc1",
+            groupuser_pb2.MemberType.manager) in [(m.name, m.type) for m in info_resp.members]
+    assert (username_perfix+"_acc2",
+            groupuser_pb2.MemberType.owner) in [(m.name, m.type) for m in info_resp.members]
+    assert len([m for m in info_resp.members if m.type ==
+               groupuser_pb2.MemberType.owner]) == 1
+
+
+@pytest.mark.asyncio
+async def test_group_rank(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp13",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    for name in ("_acc2", "_acc3", "_acc4"):
+        join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+            group_id=group_id,
+            uid=user_lst[0],
+            username=username_perfix+name
+        ))
+        assert join_resp.result.code == 800
+
+    for name in ("_acc2", "_acc4"):
+        set_resp = await group_user_stub.SetUserType(groupuser_pb2.SetUserTypeRequest(
+            group_id=group_id,
+            uid=user_lst[0],
+            username=username_perfix+name,
+            type=groupuser_pb2.MemberType.manager,
+        ))
+        assert set_resp.result.code == 800
+
+    # 管理员不能踢出群主或同级管理员
+    kick_resp = await group_user_stub.KickUser(groupuser_pb2.KickUserRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc1"
+    ))
+    assert kick_resp.result.code != 800
+
+    kick_resp = await group_user_stub.KickUser(groupuser_pb2.KickUserRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc4"
+    ))
+    assert kick_resp.result.code != 800
+
+    # 管理员只能授予低于自己的身份
+    set_resp = await group_user_stub.SetUserType(groupuser_pb2.SetUserTypeRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc3",
+        type=groupuser_pb2.MemberType.manager,
+    ))
+    assert set_resp.result.code != 800
+
+    set_resp = await group_user_stub.SetUserType(groupuser_pb2.SetUserTypeRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc4",
+        type=groupuser_pb2.MemberType.member,
+    ))
+    assert set_resp.result.code != 800
+
+    set_resp = await group_user_stub.SetUserType(groupuser_pb2.SetUserTypeRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc3",
+        type=groupuser_pb2.MemberType.other,
+    ))
+    assert set_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    # 普通成员不能踢出他人
+    kick_resp = await group_user_stub.KickUser(groupuser_pb2.KickUserRequest(
+        group_id=group_id,
+        uid=user_lst[2],
+        username=username_perfix+"_acc2"
+    ))
+    assert kick_resp.result.code != 800
+
+    kick_resp = await group_user_stub.KickUser(groupuser_pb2.KickUserRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc3"
+    ))
     assert kick_resp.result.code == 800
+
+
+@pytest.mark.asyncio
+async def test_group_leave_succession(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp14",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    for name in ("_acc2", "_acc3"):
+        join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+            group_id=group_id,
+            uid=user_lst[0],
+            username=username_perfix+name
+        ))
+        assert join_resp.result.code == 800
+
+    set_resp = await group_user_stub.SetUserType(groupuser_pb2.SetUserTypeRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc3",
+        type=groupuser_pb2.MemberType.manager,
+    ))
+    assert set_resp.result.code == 800
+
+    # 群主退出后由最早的管理员继任
+    leave_resp = await group_user_stub.LeaveGroup(groupuser_pb2.LeaveGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert leave_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    info_resp = await group_user_stub.GetGroupInfo(groupuser_pb2.GetGroupInfoRequest(
+        group_id=group_id,
+        uid=user_lst[2]
+    ))
+    assert info_resp.result.code == 800
+    assert username_perfix + \
+        "_acc1" not in [m.name for m in info_resp.members]
+    assert (username_perfix+"_acc3",
+            groupuser_pb2.MemberType.owner) in [(m.name, m.type) for m in info_resp.members]
+
+    leave_resp = await group_user_stub.LeaveGroup(groupuser_pb2.LeaveGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert leave_resp.result.code != 800
+
+    leave_resp = await group_user_stub.LeaveGroup(groupuser_pb2.LeaveGroupRequest(
+        group_id=group_id,
+        uid=user_lst[1]
+    ))
+    assert leave_resp.result.code == 800
+
+    # 最后一人退出后群组解散
+    leave_resp = await group_user_stub.LeaveGroup(groupuser_pb2.LeaveGroupRequest(
+        group_id=group_id,
+        uid=user_lst[2]
+    ))
+    assert leave_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    pinfo_resp = await group_user_stub.GetGroupPublicInfo(groupuser_pb2.GetGroupPublicInfoRequest(
+        group_id=group_id,
+    ))
+    assert pinfo_resp.result.code != 800
+
+
+@pytest.mark.asyncio
+async def test_group_join_policy(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp15",
+        uid=user_lst[0],
+        join_policy=groupuser_pb2.JoinPolicy.password,
+    ))
+    assert create_resp.result.code != 800
+
+    # 审核制群组不接受密码
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp15",
+        uid=user_lst[0],
+        join_policy=groupuser_pb2.JoinPolicy.approval,
+        password="grp15_password"
+    ))
+    assert create_resp.result.code != 800
+
+    # 只提供密码时视为密码制
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp15",
+        uid=user_lst[0],
+        password="grp15_password"
+    ))
+    assert create_resp.result.code == 800
+
+    pinfo_resp = await group_user_stub.GetGroupPublicInfo(groupuser_pb2.GetGroupPublicInfoRequest(
+        group_id=create_resp.group_id,
+    ))
+    assert pinfo_resp.result.code == 800
+    assert pinfo_resp.join_policy == groupuser_pb2.JoinPolicy.password
+
+    join_resp = await group_user_stub.JoinGroup(groupuser_pb2.JoinGroupRequest(
+        group_id=create_resp.group_id,
+        password="",
+        uid=user_lst[1]
+    ))
+    assert join_resp.result.code != 800
+
+    # 创建密码制群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp15",
+        uid=user_lst[0],
+        join_policy=groupuser_pb2.JoinPolicy.password,
+        password="grp15_password"
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    pinfo_resp = await group_user_stub.GetGroupPublicInfo(groupuser_pb2.GetGroupPublicInfoRequest(
+        group_id=group_id,
+    ))
+    assert pinfo_resp.result.code == 800
+    assert pinfo_resp.join_policy == groupuser_pb2.JoinPolicy.password
+
+    join_resp = await group_user_stub.JoinGroup(groupuser_pb2.JoinGroupRequest(
+        group_id=group_id,
+        password="",
+        uid=user_lst[1]
+    ))
+    assert join_resp.result.code != 800
+
+    join_resp = await group_user_stub.JoinGroup(groupuser_pb2.JoinGroupRequest(
+        group_id=group_id,
+        password="grp15_password",
+        uid=user_lst[1]
+    ))
+    assert join_resp.result.code == 800
+
+    # 切换为邀请制
+    policy_resp = await group_user_stub.SetJoinPolicy(groupuser_pb2.SetJoinPolicyRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        join_policy=groupuser_pb2.JoinPolicy.open
+    ))
+    assert policy_resp.result.code != 800
+
+    policy_resp = await group_user_stub.SetJoinPolicy(groupuser_pb2.SetJoinPolicyRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        join_policy=groupuser_pb2.JoinPolicy.invite
+    ))
+    assert policy_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    pinfo_resp = await group_user_stub.GetGroupPublicInfo(groupuser_pb2.GetGroupPublicInfoRequest(
+        group_id=group_id,
+    ))
+    assert pinfo_resp.result.code == 800
+    assert pinfo_resp.join_policy == groupuser_pb2.JoinPolicy.invite
+
+    join_resp = await group_user_stub.JoinGroup(groupuser_pb2.JoinGroupRequest(
+        group_id=group_id,
+        password="grp15_password",
+        uid=user_lst[2]
+    ))
+    assert join_resp.result.code != 800
+
+    # 邀请制群组中普通成员不能邀请
+    invite_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc3"
+    ))
+    assert invite_resp.result.code != 800
+
+    invite_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc3"
+    ))
+    assert invite_resp.result.code == 800
+
+    # 审核制群组不能直接加入
+    policy_resp = await group_user_stub.SetJoinPolicy(groupuser_pb2.SetJoinPolicyRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        join_policy=groupuser_pb2.JoinPolicy.approval
+    ))
+    assert policy_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    join_resp = await group_user_stub.JoinGroup(groupuser_pb2.JoinGroupRequest(
+        group_id=group_id,
+        password="",
+        uid=user_lst[3]
+    ))
+    assert join_resp.result.code != 800
+
+    # 修改密码不改变审核制
+    change_resp = await group_user_stub.ChangeGroupPassword(groupuser_pb2.ChangeGroupPasswordRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        password=""
+    ))
+    assert change_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    pinfo_resp = await group_user_stub.GetGroupPublicInfo(groupuser_pb2.GetGroupPublicInfoRequest(
+        group_id=group_id,
+    ))
+    assert pinfo_resp.result.code == 800
+    assert pinfo_resp.join_policy == groupuser_pb2.JoinPolicy.approval
+
+
+@pytest.mark.asyncio
+async def test_group_join_request(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建审核制群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp16",
+        uid=user_lst[0],
+        join_policy=groupuser_pb2.JoinPolicy.approval
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    request_resp = await group_user_stub.RequestJoin(groupuser_pb2.RequestJoinRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        message="hello"
+    ))
+    assert request_resp.result.code == 800
+    request_id1 = request_resp.request_id
+
+    request_resp = await group_user_stub.RequestJoin(groupuser_pb2.RequestJoinRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        message="hello again"
+    ))
+    assert request_resp.result.code != 800
+
+    request_resp = await group_user_stub.RequestJoin(groupuser_pb2.RequestJoinRequest(
+        group_id=group_id,
+        uid=user_lst[2],
+        message="hi"
+    ))
+    assert request_resp.result.code == 800
+    request_id2 = request_resp.request_id
+
+    list_resp = await group_user_stub.ListJoinRequests(groupuser_pb2.ListJoinRequestsRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+    ))
+    assert list_resp.result.code != 800
+
+    list_resp = await group_user_stub.ListJoinRequests(groupuser_pb2.ListJoinRequestsRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        limit=1
+    ))
+    assert list_resp.result.code == 800
+    assert [r.id for r in list_resp.requests] == [request_id1]
+    list_resp = await group_user_stub.ListJoinRequests(groupuser_pb2.ListJoinRequestsRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        cursor=list_resp.next_cursor,
+        limit=1
+    ))
+    assert list_resp.result.code == 800
+    assert [r.id for r in list_resp.requests] == [request_id2]
+
+    approve_resp = await group_user_stub.ApproveJoinRequest(groupuser_pb2.ApproveJoinRequestRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        request_id=request_id1
+    ))
+    assert approve_resp.result.code == 800
+
+    approve_resp = await group_user_stub.ApproveJoinRequest(groupuser_pb2.ApproveJoinRequestRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        request_id=request_id1
+    ))
+    assert approve_resp.result.code != 800
+
+    reject_resp = await group_user_stub.RejectJoinRequest(groupuser_pb2.RejectJoinRequestRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        request_id=request_id2,
+        reason="not now"
+    ))
+    assert reject_resp.result.code == 800
+
+    my_resp = await group_user_stub.ListMyJoinRequests(groupuser_pb2.ListMyJoinRequestsRequest(
+        uid=user_lst[2]
+    ))
+    assert my_resp.result.code == 800
+    assert (request_id2, groupuser_pb2.RequestStatus.rejected, "not now") in [
+        (r.id, r.status, r.reason) for r in my_resp.requests]
+
+    await asyncio.sleep(1)
+
+    info_resp = await group_user_stub.GetGroupInfo(groupuser_pb2.GetGroupInfoRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert info_resp.result.code == 800
+    assert (username_perfix+"_acc2",
+            groupuser_pb2.MemberType.member) in [(m.name, m.type) for m in info_resp.members]
+    assert username_perfix + \
+        "_acc3" not in [m.name for m in info_resp.members]
+
+
+@pytest.mark.asyncio
+async def test_group_invite_link(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建邀请制群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp17",
+        uid=user_lst[0],
+        join_policy=groupuser_pb2.JoinPolicy.invite
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    link_resp = await group_user_stub.CreateInviteLink(groupuser_pb2.CreateInviteLinkRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        max_uses=1
+    ))
+    assert link_resp.result.code != 800
+
+    link_resp = await group_user_stub.CreateInviteLink(groupuser_pb2.CreateInviteLinkRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        max_uses=1,
+        expires_at=int(time.time()) + 3600
+    ))
+    assert link_resp.result.code == 800
+    token = link_resp.token
+
+    list_resp = await group_user_stub.ListInviteLinks(groupuser_pb2.ListInviteLinksRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert list_resp.result.code == 800
+    assert token in [l.token for l in list_resp.links]
+
+    redeem_resp = await group_user_stub.RedeemInviteLink(groupuser_pb2.RedeemInviteLinkRequest(
+        token="0" * 32,
+        uid=user_lst[1]
+    ))
+    assert redeem_resp.result.code != 800
+
+    redeem_resp = await group_user_stub.RedeemInviteLink(groupuser_pb2.RedeemInviteLinkRequest(
+        token=token,
+        uid=user_lst[1]
+    ))
+    assert redeem_resp.result.code == 800
+    assert redeem_resp.group_id == group_id
+
+    # 使用次数已用尽
+    redeem_resp = await group_user_stub.RedeemInviteLink(groupuser_pb2.RedeemInviteLinkRequest(
+        token=token,
+        uid=user_lst[2]
+    ))
+    assert redeem_resp.result.code != 800
+
+    link_resp = await group_user_stub.CreateInviteLink(groupuser_pb2.CreateInviteLinkRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert link_resp.result.code == 800
+    token = link_resp.token
+
+    revoke_resp = await group_user_stub.RevokeInviteLink(groupuser_pb2.RevokeInviteLinkRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        token=token
+    ))
+    assert revoke_resp.result.code == 800
+
+    redeem_resp = await group_user_stub.RedeemInviteLink(groupuser_pb2.RedeemInviteLinkRequest(
+        token=token,
+        uid=user_lst[2]
+    ))
+    assert redeem_resp.result.code != 800
+
+    await asyncio.sleep(1)
+
+    info_resp = await group_user_stub.GetGroupInfo(groupuser_pb2.GetGroupInfoRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert info_resp.result.code == 800
+    assert username_perfix+"_acc2" in [m.name for m in info_resp.members]
+    assert username_perfix+"_acc3" not in [m.name for m in info_resp.members]
+
+
+@pytest.mark.asyncio
+async def test_group_invitation(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建需被邀请者确认的群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp18",
+        uid=user_lst[0]
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    invite_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert invite_resp.result.code == 800
+    assert invite_resp.is_pending
+    accept_id = invite_resp.invitation_id
+
+    # 重复邀请
+    invite_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert invite_resp.result.code != 800
+
+    invite_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc3"
+    ))
+    assert invite_resp.result.code == 800
+    assert invite_resp.is_pending
+    decline_id = invite_resp.invitation_id
+
+    list_resp = await group_user_stub.ListMyInvitations(groupuser_pb2.ListMyInvitationsRequest(
+        uid=user_lst[1]
+    ))
+    assert list_resp.result.code == 800
+    assert (accept_id, group_id) in [(i.id, i.group_id)
+                                     for i in list_resp.invitations]
+
+    info_resp = await group_user_stub.GetGroupInfo(groupuser_pb2.GetGroupInfoRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert info_resp.result.code == 800
+    assert username_perfix+"_acc2" not in [m.name for m in info_resp.members]
+
+    # 不能处理他人的邀请
+    accept_resp = await group_user_stub.AcceptInvitation(groupuser_pb2.AcceptInvitationRequest(
+        uid=user_lst[2],
+        invitation_id=accept_id
+    ))
+    assert accept_resp.result.code != 800
+
+    accept_resp = await group_user_stub.AcceptInvitation(groupuser_pb2.AcceptInvitationRequest(
+        uid=user_lst[1],
+        invitation_id=accept_id
+    ))
+    assert accept_resp.result.code == 800
+    assert accept_resp.group_id == group_id
+
+    decline_resp = await group_user_stub.DeclineInvitation(groupuser_pb2.DeclineInvitationRequest(
+        uid=user_lst[2],
+        invitation_id=decline_id
+    ))
+    assert decline_resp.result.code == 800
+
+    accept_resp = await group_user_stub.AcceptInvitation(groupuser_pb2.AcceptInvitationRequest(
+        uid=user_lst[2],
+        invitation_id=decline_id
+    ))
+    assert accept_resp.result.code != 800
+
+    list_resp = await group_user_stub.ListMyInvitations(groupuser_pb2.ListMyInvitationsRequest(
+        uid=user_lst[1]
+    ))
+    assert list_resp.result.code == 800
+    assert accept_id not in [i.id for i in list_resp.invitations]
+
+    # 群主开启直接拉人后不再需要确认
+    direct_resp = await group_user_stub.SetDirectInvite(groupuser_pb2.SetDirectInviteRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        is_direct_invite=True
+    ))
+    assert direct_resp.result.code != 800
+
+    direct_resp = await group_user_stub.SetDirectInvite(groupuser_pb2.SetDirectInviteRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert direct_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    invite_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc4"
+    ))
+    assert invite_resp.result.code == 800
+    assert not invite_resp.is_pending
+
+    await asyncio.sleep(1)
+
+    info_resp = await group_user_stub.GetGroupInfo(groupuser_pb2.GetGroupInfoRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert info_resp.result.code == 800
+    assert username_perfix+"_acc2" in [m.name for m in info_resp.members]
+    assert username_perfix+"_acc3" not in [m.name for m in info_resp.members]
+    assert username_perfix+"_acc4" in [m.name for m in info_resp.members]
+
+
+@pytest.mark.asyncio
+async def test_group_ban(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建密码制群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp19",
+        uid=user_lst[0],
+        join_policy=groupuser_pb2.JoinPolicy.password,
+        password="grp19_password",
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    for i in range(1, 3):
+        join_resp = await group_user_stub.JoinGroup(groupuser_pb2.JoinGroupRequest(
+            group_id=group_id,
+            password="grp19_password",
+            uid=user_lst[i]
+        ))
+        assert join_resp.result.code == 800
+
+    # 普通成员不能封禁
+    ban_resp = await group_user_stub.BanMember(groupuser_pb2.BanMemberRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc3"
+    ))
+    assert ban_resp.result.code != 800
+
+    ban_resp = await group_user_stub.BanMember(groupuser_pb2.BanMemberRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2",
+        reason="spam"
+    ))
+    assert ban_resp.result.code == 800
+
+    # 群外用户也可以预先封禁
+    ban_resp = await group_user_stub.BanMember(groupuser_pb2.BanMemberRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc4",
+        expires_at=int(time.time()) + 3600
+    ))
+    assert ban_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    info_resp = await group_user_stub.GetGroupInfo(groupuser_pb2.GetGroupInfoRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert info_resp.result.code == 800
+    assert username_perfix+"_acc2" not in [m.name for m in info_resp.members]
+
+    list_resp = await group_user_stub.ListBans(groupuser_pb2.ListBansRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert list_resp.result.code == 800
+    assert (username_perfix+"_acc2", "spam") in [(b.username, b.reason)
+                                                 for b in list_resp.bans]
+    assert username_perfix+"_acc4" in [b.username for b in list_resp.bans]
+
+    join_resp = await group_user_stub.JoinGroup(groupuser_pb2.JoinGroupRequest(
+        group_id=group_id,
+        password="grp19_password",
+        uid=user_lst[1]
+    ))
+    assert join_resp.result.code != 800
+
+    invite_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc4"
+    ))
+    assert invite_resp.result.code != 800
+
+    link_resp = await group_user_stub.CreateInviteLink(groupuser_pb2.CreateInviteLinkRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert link_resp.result.code == 800
+
+    redeem_resp = await group_user_stub.RedeemInviteLink(groupuser_pb2.RedeemInviteLinkRequest(
+        token=link_resp.token,
+        uid=user_lst[1]
+    ))
+    assert redeem_resp.result.code != 800
+
+    # 管理员不能解除或改写群主设置的封禁
+    set_resp = await group_user_stub.SetUserType(groupuser_pb2.SetUserTypeRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc3",
+        type=groupuser_pb2.MemberType.manager,
+    ))
+    assert set_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    unban_resp = await group_user_stub.UnbanMember(groupuser_pb2.UnbanMemberRequest(
+        group_id=group_id,
+        uid=user_lst[2],
+        username=username_perfix+"_acc2"
+    ))
+    assert unban_resp.result.code == CODE_PERMISSION_DENIED
+
+    ban_resp = await group_user_stub.BanMember(groupuser_pb2.BanMemberRequest(
+        group_id=group_id,
+        uid=user_lst[2],
+        username=username_perfix+"_acc2"
+    ))
+    assert ban_resp.result.code == CODE_PERMISSION_DENIED
+
+    unban_resp = await group_user_stub.UnbanMember(groupuser_pb2.UnbanMemberRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert unban_resp.result.code == 800
+
+    join_resp = await group_user_stub.JoinGroup(groupuser_pb2.JoinGroupRequest(
+        group_id=group_id,
+        password="grp19_password",
+        uid=user_lst[1]
+    ))
+    assert join_resp.result.code == 800
+
+
+@pytest.mark.asyncio
+async def test_group_mute(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp20",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    for i in range(2, 4):
+        join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+            group_id=group_id,
+            uid=user_lst[0],
+            username=username_perfix+"_acc"+str(i)
+        ))
+        assert join_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    until = int(time.time()) + 3600
+
+    # 普通成员不能禁言
+    mute_resp = await group_user_stub.MuteMember(groupuser_pb2.MuteMemberRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc3",
+        until=until
+    ))
+    assert mute_resp.result.code != 800
+
+    # 不能禁言群主
+    mute_resp = await group_user_stub.MuteMember(groupuser_pb2.MuteMemberRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc1",
+        until=until
+    ))
+    assert mute_resp.result.code != 800
+
+    mute_resp = await group_user_stub.MuteMember(groupuser_pb2.MuteMemberRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2",
+        until=int(time.time()) - 1
+    ))
+    assert mute_resp.result.code != 800
+
+    mute_resp = await group_user_stub.MuteMember(groupuser_pb2.MuteMemberRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2",
+        until=until
+    ))
+    assert mute_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    res_resp = await group_user_stub.GetMemberRestrictions(groupuser_pb2.GetMemberRestrictionsRequest(
+        group_id=group_id,
+        uid=user_lst[1]
+    ))
+    assert res_resp.result.code == 800
+    assert res_resp.is_muted
+    assert res_resp.muted_until == until
+
+    res_resp = await group_user_stub.GetMemberRestrictions(groupuser_pb2.GetMemberRestrictionsRequest(
+        group_id=group_id,
+        uid=user_lst[2]
+    ))
+    assert res_resp.result.code == 800
+    assert not res_resp.is_muted
+
+    unmute_resp = await group_user_stub.UnmuteMember(groupuser_pb2.UnmuteMemberRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert unmute_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    res_resp = await group_user_stub.GetMemberRestrictions(groupuser_pb2.GetMemberRestrictionsRequest(
+        group_id=group_id,
+        uid=user_lst[1]
+    ))
+    assert res_resp.result.code == 800
+    assert not res_resp.is_muted
+
+    # 踢出后禁言随之清除，重新加入不再禁言
+    mute_resp = await group_user_stub.MuteMember(groupuser_pb2.MuteMemberRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc3",
+        until=until
+    ))
+    assert mute_resp.result.code == 800
+
+    kick_resp = await group_user_stub.KickUser(groupuser_pb2.KickUserRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc3"
+    ))
+    assert kick_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc3"
+    ))
+    assert join_resp.result.code == 800
+
+    res_resp = await group_user_stub.GetMemberRestrictions(groupuser_pb2.GetMemberRestrictionsRequest(
+        group_id=group_id,
+        uid=user_lst[2]
+    ))
+    assert res_resp.result.code == 800
+    assert not res_resp.is_muted
+
+
+@pytest.mark.asyncio
+async def test_group_permissions(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp21",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert join_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    perm_resp = await group_user_stub.GetGroupPermissions(groupuser_pb2.GetGroupPermissionsRequest(
+        group_id=group_id,
+        uid=user_lst[1]
+    ))
+    assert perm_resp.result.code == 800
+    member_actions = [p.actions for p in perm_resp.permissions
+                      if p.type == groupuser_pb2.MemberType.member][0]
+    assert groupuser_pb2.GroupAction.invite in member_actions
+    assert groupuser_pb2.GroupAction.rename not in member_actions
+
+    rename_resp = await group_user_stub.ChangeGroupName(groupuser_pb2.ChangeGroupNameRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        name="grp21_new"
+    ))
+    assert rename_resp.result.code != 800
+
+    # 只有群主可以修改权限
+    set_resp = await group_user_stub.SetGroupPermissions(groupuser_pb2.SetGroupPermissionsRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        permissions=[groupuser_pb2.RolePermission(
+            type=groupuser_pb2.MemberType.member,
+            actions=[groupuser_pb2.GroupAction.rename]
+        )]
+    ))
+    assert set_resp.result.code != 800
+
+    set_resp = await group_user_stub.SetGroupPermissions(groupuser_pb2.SetGroupPermissionsRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        permissions=[groupuser_pb2.RolePermission(
+            type=groupuser_pb2.MemberType.owner,
+            actions=[]
+        )]
+    ))
+    assert set_resp.result.code != 800
+
+    set_resp = await group_user_stub.SetGroupPermissions(groupuser_pb2.SetGroupPermissionsRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        permissions=[groupuser_pb2.RolePermission(
+            type=groupuser_pb2.MemberType.member,
+            actions=[groupuser_pb2.GroupAction.rename,
+                     groupuser_pb2.GroupAction.view_members]
+        )]
+    ))
+    assert set_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    rename_resp = await group_user_stub.ChangeGroupName(groupuser_pb2.ChangeGroupNameRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        name="grp21_new"
+    ))
+    assert rename_resp.result.code == 800
+
+    # 普通成员已失去邀请权限
+    invite_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc3"
+    ))
+    assert invite_resp.result.code != 800
+
+    invite_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc3"
+    ))
+    assert invite_resp.result.code == 800
+
+
+@pytest.mark.asyncio
+async def test_group_custom_role(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp22",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    for i in range(2, 5):
+        join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+            group_id=group_id,
+            uid=user_lst[0],
+            username=username_perfix+"_acc"+str(i)
+        ))
+        assert join_resp.result.code == 800
+
+    # 只有群主可以定义角色
+    role_resp = await group_user_stub.CreateRole(groupuser_pb2.CreateRoleRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        name="moderator",
+        rank=150,
+        actions=[groupuser_pb2.GroupAction.kick]
+    ))
+    assert role_resp.result.code != 800
+
+    # 角色等级不能与群主平级
+    role_resp = await group_user_stub.CreateRole(groupuser_pb2.CreateRoleRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        name="moderator",
+        rank=300,
+        actions=[groupuser_pb2.GroupAction.kick]
+    ))
+    assert role_resp.result.code != 800
+
+    role_resp = await group_user_stub.CreateRole(groupuser_pb2.CreateRoleRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        name="moderator",
+        rank=150,
+        actions=[groupuser_pb2.GroupAction.kick,
+                 groupuser_pb2.GroupAction.view_members]
+    ))
+    assert role_resp.result.code == 800
+    role_id = role_resp.role_id
+
+    role_resp = await group_user_stub.CreateRole(groupuser_pb2.CreateRoleRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        name="moderator",
+        rank=120
+    ))
+    assert role_resp.result.code != 800
+
+    list_resp = await group_user_stub.ListRoles(groupuser_pb2.ListRolesRequest(
+        group_id=group_id,
+        uid=user_lst[1]
+    ))
+    assert list_resp.result.code == 800
+    assert "moderator" in [r.name for r in list_resp.roles]
+    assert "owner" in [r.name for r in list_resp.roles if r.is_builtin]
+
+    await asyncio.sleep(1)
+
+    assign_resp = await group_user_stub.AssignRole(groupuser_pb2.AssignRoleRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc3",
+        role_id=role_id
+    ))
+    assert assign_resp.result.code != 800
+
+    assign_resp = await group_user_stub.AssignRole(groupuser_pb2.AssignRoleRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2",
+        role_id=role_id
+    ))
+    assert assign_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    info_resp = await group_user_stub.GetGroupInfo(groupuser_pb2.GetGroupInfoRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert info_resp.result.code == 800
+    assert (username_perfix+"_acc2", "moderator") in [(m.name, m.role)
+                                                      for m in info_resp.members]
+    assert (username_perfix+"_acc3", "member") in [(m.name, m.role)
+                                                   for m in info_resp.members]
+
+    # 自定义角色可以踢出等级更低的成员
+    kick_resp = await group_user_stub.KickUser(groupuser_pb2.KickUserRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc3"
+    ))
+    assert kick_resp.result.code == 800
+
+    delete_resp = await group_user_stub.DeleteRole(groupuser_pb2.DeleteRoleRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        role_id=role_id
+    ))
+    assert delete_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    # 删除角色后恢复为普通成员，失去踢人权限
+    kick_resp = await group_user_stub.KickUser(groupuser_pb2.KickUserRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc4"
+    ))
+    assert kick_resp.result.code != 800
+
+
+@pytest.mark.asyncio
+async def test_group_member_profile(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp23",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert join_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    profile_resp = await group_user_stub.SetMemberProfile(groupuser_pb2.SetMemberProfileRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        nickname="card2"
+    ))
+    assert profile_resp.result.code == 800
+
+    # 普通成员不能设置头衔
+    profile_resp = await group_user_stub.SetMemberProfile(groupuser_pb2.SetMemberProfileRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        title="self_title"
+    ))
+    assert profile_resp.result.code != 800
+
+    profile_resp = await group_user_stub.SetMemberProfile(groupuser_pb2.SetMemberProfileRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        username=username_perfix+"_acc1",
+        nickname="boss"
+    ))
+    assert profile_resp.result.code != 800
+
+    profile_resp = await group_user_stub.SetMemberProfile(groupuser_pb2.SetMemberProfileRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2",
+        title="elder"
+    ))
+    assert profile_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    info_resp = await group_user_stub.GetGroupInfo(groupuser_pb2.GetGroupInfoRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert info_resp.result.code == 800
+    assert (username_perfix+"_acc2", "card2", "elder") in [(m.name, m.nickname, m.title)
+                                                           for m in info_resp.members]
+
+
+@pytest.mark.asyncio
+async def test_group_member_metadata(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp24",
+        uid=user_lst[0],
+        join_policy=groupuser_pb2.JoinPolicy.password,
+        password="grp24pwd",
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert join_resp.result.code == 800
+
+    join_resp = await group_user_stub.JoinGroup(groupuser_pb2.JoinGroupRequest(
+        group_id=group_id,
+        uid=user_lst[2],
+        password="grp24pwd"
+    ))
+    assert join_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    info_resp = await group_user_stub.GetGroupInfo(groupuser_pb2.GetGroupInfoRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert info_resp.result.code == 800
+    members = {m.name: m for m in info_resp.members}
+    assert members[username_perfix+"_acc1"].join_method == groupuser_pb2.JoinMethod.creator
+    assert members[username_perfix+"_acc2"].join_method == groupuser_pb2.JoinMethod.invite
+    assert members[username_perfix+"_acc2"].invited_by_uid == user_lst[0]
+    assert members[username_perfix+"_acc3"].join_method == groupuser_pb2.JoinMethod.password
+    assert members[username_perfix+"_acc3"].invited_by_uid == 0
+    assert all(m.joined_at > 0 for m in info_resp.members)
+
+
+@pytest.mark.asyncio
+async def test_group_list_members(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp25",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    for i in range(2, 5):
+        join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+            group_id=group_id,
+            uid=user_lst[0],
+            username=username_perfix+f"_acc{i}"
+        ))
+        assert join_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    # 分页遍历全部成员
+    names = []
+    cursor = ""
+    while True:
+        list_resp = await group_user_stub.ListMembers(groupuser_pb2.ListMembersRequest(
+            group_id=group_id,
+            uid=user_lst[1],
+            cursor=cursor,
+            limit=3
+        ))
+        assert list_resp.result.code == 800
+        assert list_resp.member_count == 4
+        names += [m.name for m in list_resp.members]
+        cursor = list_resp.next_cursor
+        if cursor == "":
+            break
+    assert sorted(names) == names
+    assert set(names) == {username_perfix+f"_acc{i}" for i in range(1, 5)}
+
+    list_resp = await group_user_stub.ListMembers(groupuser_pb2.ListMembersRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        role_filter=groupuser_pb2.MemberType.owner
+    ))
+    assert list_resp.result.code == 800
+    assert [m.name for m in list_resp.members] == [username_perfix+"_acc1"]
+
+    list_resp = await group_user_stub.ListMembers(groupuser_pb2.ListMembersRequest(
+        group_id=group_id,
+        uid=user_lst[1],
+        name_prefix=username_perfix+"_acc3"
+    ))
+    assert list_resp.result.code == 800
+    assert [m.name for m in list_resp.members] == [username_perfix+"_acc3"]
+
+
+@pytest.mark.asyncio
+async def test_group_check_membership(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp26",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert join_resp.result.code == 800
+
+    check_resp = await group_user_stub.CheckMembership(groupuser_pb2.CheckMembershipRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert check_resp.result.code == 800
+    assert check_resp.status.is_member
+    assert check_resp.status.member.type == groupuser_pb2.MemberType.owner
+
+    check_resp = await group_user_stub.CheckMemberships(groupuser_pb2.CheckMembershipsRequest(
+        keys=[groupuser_pb2.MembershipKey(group_id=group_id, uid=uid) for uid in user_lst[1:3]]
+    ))
+    assert check_resp.result.code == 800
+    assert [s.is_member for s in check_resp.statuses] == [True, False]
+
+    set_resp = await group_user_stub.SetUserType(groupuser_pb2.SetUserTypeRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2",
+        type=groupuser_pb2.MemberType.manager
+    ))
+    assert set_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    check_resp = await group_user_stub.CheckMembership(groupuser_pb2.CheckMembershipRequest(
+        group_id=group_id,
+        uid=user_lst[1]
+    ))
+    assert check_resp.result.code == 800
+    assert check_resp.status.member.type == groupuser_pb2.MemberType.manager
+
+    kick_resp = await group_user_stub.KickUser(groupuser_pb2.KickUserRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert kick_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    check_resp = await group_user_stub.CheckMembership(groupuser_pb2.CheckMembershipRequest(
+        group_id=group_id,
+        uid=user_lst[1]
+    ))
+    assert check_resp.result.code == 800
+    assert not check_resp.status.is_member
+
+
+@pytest.mark.asyncio
+async def test_group_batch_read(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp27",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert join_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    batch_resp = await group_user_stub.BatchGetGroupPublicInfo(groupuser_pb2.BatchGetGroupPublicInfoRequest(
+        group_ids=[group_id, 999999999]
+    ))
+    assert batch_resp.result.code == 800
+    assert len(batch_resp.results) == 2
+    assert batch_resp.results[0].result.code == 800
+    assert batch_resp.results[0].name == "grp27"
+    assert batch_resp.results[1].result.code != 800
+
+    batch_resp = await group_user_stub.BatchGetGroupsByUID(groupuser_pb2.BatchGetGroupsByUIDRequest(
+        uids=user_lst[0:3]
+    ))
+    assert batch_resp.result.code == 800
+    assert len(batch_resp.results) == 3
+    assert all(r.result.code == 800 for r in batch_resp.results)
+    assert group_id in batch_resp.results[0].groups
+    assert group_id in batch_resp.results[1].groups
+    assert group_id not in batch_resp.results[2].groups
+
+
+@pytest.mark.asyncio
+async def test_group_rich_groups_by_uid(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    group_ids = []
+    for name in ["grp28", "grp29"]:
+        create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+            name=name,
+            uid=user_lst[3],
+            is_direct_invite=True
+        ))
+        assert create_resp.result.code == 800
+        group_ids.append(create_resp.group_id)
+
+    pref_resp = await group_user_stub.SetGroupPreference(groupuser_pb2.SetGroupPreferenceRequest(
+        group_id=group_ids[1],
+        uid=user_lst[3],
+        is_pinned=True
+    ))
+    assert pref_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    # 分页遍历带摘要的群组列表
+    summaries = []
+    cursor = 0
+    while True:
+        groups_resp = await group_user_stub.GetGroupsByUID(groupuser_pb2.GetGroupsByUIDRequest(
+            uid=user_lst[3],
+            detailed=True,
+            cursor=cursor,
+            limit=1
+        ))
+        assert groups_resp.result.code == 800
+        summaries += list(groups_resp.summaries)
+        cursor = groups_resp.next_cursor
+        if cursor == 0:
+            break
+    summary_map = {s.group_id: s for s in summaries}
+    assert summary_map[group_ids[0]].name == "grp28"
+    assert summary_map[group_ids[0]].type == groupuser_pb2.MemberType.owner
+    assert summary_map[group_ids[0]].member_count == 1
+    assert summary_map[group_ids[0]].joined_at > 0
+    assert not summary_map[group_ids[0]].is_pinned
+    assert summary_map[group_ids[1]].is_pinned
+
+
+@pytest.mark.asyncio
+async def test_group_audit_log(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp30",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert join_resp.result.code == 800
+
+    rename_resp = await group_user_stub.ChangeGroupName(groupuser_pb2.ChangeGroupNameRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        name="grp30_new"
+    ))
+    assert rename_resp.result.code == 800
+
+    await asyncio.sleep(1)
+
+    # 普通成员无权查看审计记录
+    audit_resp = await group_user_stub.ListGroupAuditLog(groupuser_pb2.ListGroupAuditLogRequest(
+        group_id=group_id,
+        uid=user_lst[1]
+    ))
+    assert audit_resp.result.code != 800
+
+    audit_resp = await group_user_stub.ListGroupAuditLog(groupuser_pb2.ListGroupAuditLogRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert audit_resp.result.code == 800
+    assert [e.action for e in audit_resp.entries] == [
+        groupuser_pb2.AuditAction.rename,
+        groupuser_pb2.AuditAction.invite,
+        groupuser_pb2.AuditAction.create_group,
+    ]
+    assert (audit_resp.entries[0].old_value, audit_resp.entries[0].new_value) == ("grp30", "grp30_new")
+
+    audit_resp = await group_user_stub.ListGroupAuditLog(groupuser_pb2.ListGroupAuditLogRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        action_filter=groupuser_pb2.AuditAction.invite
+    ))
+    assert audit_resp.result.code == 800
+    assert [e.target for e in audit_resp.entries] == [username_perfix+"_acc2"]
+
+
+@pytest.mark.asyncio
+async def test_group_watch_events(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp31",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    await asyncio.sleep(1)
+
+    # 以用户身份订阅，加入新群组后自动关注
+    async with group_user_stub.WatchGroupEvents.open() as stream:
+        await stream.send_message(groupuser_pb2.WatchGroupEventsRequest(uid=user_lst[1]), end=True)
+        await asyncio.sleep(1)
+
+        join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+            group_id=group_id,
+            uid=user_lst[0],
+            username=username_perfix+"_acc2"
+        ))
+        assert join_resp.result.code == 800
+        rename_resp = await group_user_stub.ChangeGroupName(groupuser_pb2.ChangeGroupNameRequest(
+            group_id=group_id,
+            uid=user_lst[0],
+            name="grp31_new"
+        ))
+        assert rename_resp.result.code == 800
+
+        joined = await asyncio.wait_for(stream.recv_message(), 5)
+        assert joined.result.code == 800
+        assert joined.event.type == groupuser_pb2.GroupEventType.member_joined
+        assert joined.event.username == username_perfix+"_acc2"
+        renamed = await asyncio.wait_for(stream.recv_message(), 5)
+        assert renamed.event.type == groupuser_pb2.GroupEventType.group_renamed
+        assert renamed.event.new_value == "grp31_new"
+        assert renamed.event.seq > joined.event.seq
+        await stream.cancel()
+
+    # 从加入事件的序号续传，补发改名事件
+    async with group_user_stub.WatchGroupEvents.open() as stream:
+        await stream.send_message(groupuser_pb2.WatchGroupEventsRequest(
+            uid=user_lst[1],
+            group_ids=[group_id],
+            from_seq=joined.event.seq
+        ), end=True)
+        resumed = await asyncio.wait_for(stream.recv_message(), 5)
+        assert resumed.result.code == 800
+        assert resumed.event.seq == renamed.event.seq
+        await stream.cancel()
+
+    # 无效的续传序号
+    async with group_user_stub.WatchGroupEvents.open() as stream:
+        await stream.send_message(groupuser_pb2.WatchGroupEventsRequest(
+            uid=user_lst[1],
+            group_ids=[group_id],
+            from_seq=1
+        ), end=True)
+        expired = await asyncio.wait_for(stream.recv_message(), 5)
+        assert expired.result.code != 800
+
+
+async def start_webhook_receiver(received: list):
+    # 本地 HTTP 回调接收端，记录请求头与请求体后返回 200
+    async def handle(reader: asyncio.StreamReader, writer: asyncio.StreamWriter):
+        head = await reader.readuntil(b"\r\n\r\n")
+        headers = {}
+        for line in head.decode().split("\r\n")[1:]:
+            if ":" in line:
+                key, value = line.split(":", 1)
+                headers[key.strip().lower()] = value.strip()
+        body = await reader.readexactly(int(headers.get("content-length", "0")))
+        received.append((headers, body))
+        writer.write(b"HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+        await writer.drain()
+        writer.close()
+
+    server = await asyncio.start_server(handle, "127.0.0.1", 0)
+    return server, server.sockets[0].getsockname()[1]
+
+
+@pytest.mark.asyncio
+async def test_group_webhook(group_user_stub: StealthIMGroupUserStub, user_lst: list):
+    # 需在服务端配置中同时启用 outbox 与 webhook，并放行 127.0.0.1
+    received = []
+    server, port = await start_webhook_receiver(received)
+    secret = "grp32_secret"
+
+    # 创建群组
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp32",
+        uid=user_lst[0],
+        is_direct_invite=True
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    # 非法地址
+    register_resp = await group_user_stub.RegisterWebhook(groupuser_pb2.RegisterWebhookRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        url="ftp://127.0.0.1/hook",
+        secret=secret
+    ))
+    assert register_resp.result.code != 800
+
+    # 链路本地与内网地址不允许注册
+    for url in ["http://169.254.169.254/latest/meta-data", "http://10.0.0.1/hook", "http://[::ffff:169.254.169.254]/hook"]:
+        register_resp = await group_user_stub.RegisterWebhook(groupuser_pb2.RegisterWebhookRequest(
+            group_id=group_id,
+            uid=user_lst[0],
+            url=url,
+            secret=secret
+        ))
+        assert register_resp.result.code != 800
+
+    register_resp = await group_user_stub.RegisterWebhook(groupuser_pb2.RegisterWebhookRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        url=f"http://127.0.0.1:{port}/hook",
+        events=[groupuser_pb2.GroupEventType.member_joined, groupuser_pb2.GroupEventType.member_left],
+        secret=secret
+    ))
+    assert register_resp.result.code == 800
+    webhook_id = register_resp.webhook_id
+
+    list_resp = await group_user_stub.ListWebhooks(groupuser_pb2.ListWebhooksRequest(
+        group_id=group_id,
+        uid=user_lst[0]
+    ))
+    assert list_resp.result.code == 800
+    assert [w.id for w in list_resp.webhooks] == [webhook_id]
+    assert list(list_resp.webhooks[0].events) == [
+        groupuser_pb2.GroupEventType.member_joined,
+        groupuser_pb2.GroupEventType.member_left,
+    ]
+
+    # 触发订阅的加入事件与未订阅的改名事件
+    join_resp = await group_user_stub.InviteGroup(groupuser_pb2.InviteGroupRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        username=username_perfix+"_acc2"
+    ))
+    assert join_resp.result.code == 800
+
+    # 普通成员不能管理回调
+    list_resp = await group_user_stub.ListWebhooks(groupuser_pb2.ListWebhooksRequest(
+        group_id=group_id,
+        uid=user_lst[1]
+    ))
+    assert list_resp.result.code != 800
+
+    rename_resp = await group_user_stub.ChangeGroupName(groupuser_pb2.ChangeGroupNameRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        name="grp32_new"
+    ))
+    assert rename_resp.result.code == 800
+
+    for _ in range(15):
+        if received:
+            break
+        await asyncio.sleep(1)
+    await asyncio.sleep(3)
+    assert len(received) == 1
+    headers, body = received[0]
+    assert headers["x-stealthim-event"] == "member_joined"
+    expected = "sha256=" + hmac.new(
+        secret.encode(), headers["x-stealthim-timestamp"].encode() + b"." + body, hashlib.sha256
+    ).hexdigest()
+    assert hmac.compare_digest(headers["x-stealthim-signature"], expected)
+    payload = json.loads(body)
+    assert payload["username"] == username_perfix+"_acc2"
+
+    delete_resp = await group_user_stub.DeleteWebhook(groupuser_pb2.DeleteWebhookRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        webhook_id=webhook_id
+    ))
+    assert delete_resp.result.code == 800
+    delete_resp = await group_user_stub.DeleteWebhook(groupuser_pb2.DeleteWebhookRequest(
+        group_id=group_id,
+        uid=user_lst[0],
+        webhook_id=webhook_id
+    ))
+    assert delete_resp.result.code != 800
+
+    server.close()
+    await server.wait_closed()
+
+
+async def query_outbox_events(stub: StealthIMDBGatewayStub, group_id: int):
+    resp = await stub.Mysql(db_gateway_pb2.SqlRequest(
+        sql="SELECT `event_type`, `username` FROM `group_outbox` WHERE `groupid` = ? ORDER BY `id`",
+        db=db_gateway_pb2.SqlDatabases.Groups,
+        params=[db_gateway_pb2.InterFaceType(int32=group_id)]
+    ))
+    assert resp.result.code == 800
+    return [(row.result[0].str, row.result[1].str) for row in resp.data]
+
+
+@pytest.mark.asyncio
+async def test_group_outbox(group_user_stub: StealthIMGroupUserStub, db_gateway_stub: StealthIMDBGatewayStub, user_lst: list):
+    create_resp = await group_user_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp33",
+        uid=user_lst[0]
+    ))
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    join_resp = await group_user_stub.JoinGroup(groupuser_pb2.JoinGroupRequest(
+        group_id=group_id,
+        password="",
+        uid=user_lst[1]
+    ))
+    assert join_resp.result.code == 800
+
+    # 事件与成员变化在同一请求中写入发件箱
+    events = await query_outbox_events(db_gateway_stub, group_id)
+    assert ("group_created", username_perfix+"_acc1") in events
+    assert ("member_joined", username_perfix+"_acc2") in events
+
+    # 未生效的变化不写入事件
+    join_resp = await group_user_stub.JoinGroup(groupuser_pb2.JoinGroupRequest(
+        group_id=group_id,
+        password="",
+        uid=user_lst[1]
+    ))
+    assert join_resp.result.code != 800
+    assert await query_outbox_events(db_gateway_stub, group_id) == events
+
+
+async def login_session(stub: StealthIMUserStub, username: str, password="password"):
+    response = await stub.Login(user_pb2.LoginRequest(
+        username=username,
+        password=password
+    ))
+    assert response.result.code == 800
+    return response.session
+
+
+@pytest.mark.asyncio
+async def test_group_auth(group_user_auth_stub: StealthIMGroupUserStub, user_stub: StealthIMUserStub, user_lst: list):
+    session1 = await login_session(user_stub, username_perfix+"_acc1")
+    session2 = await login_session(user_stub, username_perfix+"_acc2")
+
+    # 缺少会话
+    create_resp = await group_user_auth_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp34",
+        uid=user_lst[0]
+    ))
+    assert create_resp.result.code == CODE_UNAUTHENTICATED
+
+    # 无效会话
+    create_resp = await group_user_auth_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp34",
+        uid=user_lst[0]
+    ), metadata={"session": "invalid_session"})
+    assert create_resp.result.code == CODE_UNAUTHENTICATED
+
+    # uid 与会话不符
+    create_resp = await group_user_auth_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp34",
+        uid=user_lst[1]
+    ), metadata={"session": session1})
+    assert create_resp.result.code == CODE_PERMISSION_DENIED
+
+    # 未填写 uid 时使用会话 uid
+    create_resp = await group_user_auth_stub.CreateGroup(groupuser_pb2.CreateGroupRequest(
+        name="grp34"
+    ), metadata={"session": session1})
+    assert create_resp.result.code == 800
+    group_id = create_resp.group_id
+
+    join_resp = await group_user_auth_stub.JoinGroup(groupuser_pb2.JoinGroupRequest(
+        group_id=group_id,
+        password=""
+    ), metadata={"session": session2})
+    assert join_resp.result.code == 800
+
+    # 转让群主以 from_uid 表示调用者
+    transfer_resp = await group_user_auth_stub.TransferOwnership(groupuser_pb2.TransferOwnershipRequest(
+        group_id=group_id,
+        from_uid=user_lst[0],
+        to_username=username_perfix+"_acc2"
+    ), metadata={"session": session2})
+    assert transfer_resp.result.code == CODE_PERMISSION_DENIED
+
+    # 授权表拒绝非群主调用仅限群主的方法
+    perm_resp = await group_user_auth_stub.SetGroupPermissions(groupuser_pb2.SetGroupPermissionsRequest(
+        group_id=group_id,
+        permissions=[groupuser_pb2.RolePermission(
+            type=groupuser_pb2.MemberType.member,
+            actions=[groupuser_pb2.GroupAction.kick]
+        )]
+    ), metadata={"session": session2})
+    assert perm_resp.result.code == CODE_PERMISSION_DENIED
+
+    perm_resp = await group_user_auth_stub.SetGroupPermissions(groupuser_pb2.SetGroupPermissionsRequest(
+        group_id=group_id,
+        permissions=[groupuser_pb2.RolePermission(
+            type=groupuser_pb2.MemberType.member,
+            actions=[groupuser_pb2.GroupAction.invite]
+        )]
+    ), metadata={"session": session1})
+    assert perm_resp.result.code == 800
+
+    # 查询他人成员身份的方法仅限服务调用
+    check_resp = await group_user_auth_stub.CheckMembership(groupuser_pb2.CheckMembershipRequest(
+        group_id=group_id,
+        uid=user_lst[1]
+    ), metadata={"session": session1})
+    assert check_resp.result.code == CODE_PERMISSION_DENIED
+
+    batch_resp = await group_user_auth_stub.BatchGetGroupsByUID(groupuser_pb2.BatchGetGroupsByUIDRequest(
+        uids=[user_lst[1]]
+    ), metadata={"session": session1})
+    assert batch_resp.result.code == CODE_PERMISSION_DENIED
+
+    # 流式方法同样在响应中返回结果
+    async with group_user_auth_stub.WatchGroupEvents.open() as stream:
+        await stream.send_message(groupuser_pb2.WatchGroupEventsRequest(uid=user_lst[0]), end=True)
+        denied = await asyncio.wait_for(stream.recv_message(), 5)
+        assert denied.result.code == CODE_UNAUTHENTICATED
+
+
+@pytest.mark.asyncio
+async def test_group_auth_unknown_method(group_user_auth_channel: Channel, group_user_channel: Channel):
+    # 服务启动时检查全部方法均已登记授权要求，未登记的方法不会被执行
+    service = groupuser_pb2.DESCRIPTOR.services_by_name["StealthIMGroupUser"].full_name
+    for channel in (group_user_auth_channel, group_user_channel):
+        async with channel.request(f"/{service}/NoSuchMethod", Cardinality.UNARY_UNARY,
+                                   groupuser_pb2.PingRequest, groupuser_pb2.Pong) as stream:
+            await stream.send_message(groupuser_pb2.PingRequest(), end=True)
+            with pytest.raises(GRPCError):
+                await stream.recv_message()
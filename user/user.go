@@ -2,6 +2,7 @@ package user
 
 import (
 	pb "StealthIMGroupUser/StealthIM.User"
+	"StealthIMGroupUser/cert"
 	"StealthIMGroupUser/config"
 	"context"
 	"fmt"
@@ -10,7 +11,6 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 var conns []*grpc.ClientConn
@@ -18,9 +18,14 @@ var mainlock sync.RWMutex
 
 func createConn(connID int) {
 	log.Printf("[DB]Connect %d", connID+1)
+	creds, err := cert.ClientCredentials(config.LatestConfig.User.TLS)
+	if err != nil {
+		log.Printf("[DB]Connect %d TLS Error %v\n", connID+1, err)
+		conns[connID] = nil
+		return
+	}
 	conn, err := grpc.NewClient(fmt.Sprintf("%s:%d", config.LatestConfig.User.Host, config.LatestConfig.User.Port),
-		grpc.WithTransportCredentials(
-			insecure.NewCredentials()))
+		grpc.WithTransportCredentials(creds))
 	if conn == nil {
 		log.Printf("[DB]Connect %d Error %v\n", connID+1, err)
 		conns[connID] = nil
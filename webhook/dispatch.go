@@ -0,0 +1,201 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	"StealthIMGroupUser/config"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+	"StealthIMGroupUser/outbox"
+)
+
+const (
+	// leaseSeconds 投递期间占用任务的时长，超时后可被其它实例重新投递
+	leaseSeconds = 60
+	// maxErrorLength 记录的错误信息最大长度
+	maxErrorLength = 255
+)
+
+// delivery 待投递的回调任务
+type delivery struct {
+	id              int64
+	eventSeq        int64
+	eventType       string
+	payload         string
+	attempts        int32
+	nextAttemptTime int64
+	url             string
+	secret          string
+}
+
+// queryPending 读取已到重试时间的回调任务，已删除回调的任务不再投递
+func queryPending(limit int32) ([]*delivery, error) {
+	sqlReq := &pb_gtw.SqlRequest{
+		Sql: "SELECT t1.`id`, t1.`event_seq`, t1.`event_type`, t1.`payload`, t1.`attempts`, t1.`next_attempt_time`, t2.`url`, t2.`secret` " +
+			"FROM `group_webhook_delivery` AS t1 JOIN `group_webhook` AS t2 ON t2.`id` = t1.`webhook_id` " +
+			"WHERE t1.`status` = 'pending' AND t1.`next_attempt_time` <= UNIX_TIMESTAMP() ORDER BY t1.`id` LIMIT ?",
+		Db: pb_gtw.SqlDatabases_Groups,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: limit}},
+		},
+	}
+	sqlResp, err := gateway.ExecSQL(sqlReq)
+	if err != nil {
+		return nil, err
+	}
+	if sqlResp.Result.Code != errorcode.Success {
+		return nil, fmt.Errorf("[%d]%s", sqlResp.Result.Code, sqlResp.Result.Msg)
+	}
+	var pending []*delivery
+	for _, row := range sqlResp.Data {
+		if len(row.Result) < 8 {
+			continue
+		}
+		pending = append(pending, &delivery{
+			id:              row.Result[0].GetInt64(),
+			eventSeq:        row.Result[1].GetInt64(),
+			eventType:       row.Result[2].GetStr(),
+			payload:         row.Result[3].GetStr(),
+			attempts:        row.Result[4].GetInt32(),
+			nextAttemptTime: row.Result[5].GetInt64(),
+			url:             row.Result[6].GetStr(),
+			secret:          row.Result[7].GetStr(),
+		})
+	}
+	return pending, nil
+}
+
+// claim 占用任务，避免多个实例重复投递
+func claim(task *delivery) bool {
+	updateReq := &pb_gtw.SqlRequest{
+		Sql:    "UPDATE `group_webhook_delivery` SET `next_attempt_time` = UNIX_TIMESTAMP() + ? WHERE `id` = ? AND `status` = 'pending' AND `next_attempt_time` = ?",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: leaseSeconds}},
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: task.id}},
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: task.nextAttemptTime}},
+		},
+		GetRowCount: true,
+	}
+	updateResp, err := gateway.ExecSQL(updateReq)
+	return err == nil && updateResp.Result.Code == errorcode.Success && updateResp.RowsAffected > 0
+}
+
+// post 发送一次签名回调
+func post(client *http.Client, task *delivery) error {
+	body := []byte(task.payload)
+	timestamp := time.Now().Unix()
+	httpReq, err := http.NewRequest(http.MethodPost, task.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-StealthIM-Event", task.eventType)
+	httpReq.Header.Set("X-StealthIM-Delivery", strconv.FormatInt(task.id, 10))
+	httpReq.Header.Set("X-StealthIM-Timestamp", strconv.FormatInt(timestamp, 10))
+	httpReq.Header.Set("X-StealthIM-Signature", Sign(task.secret, timestamp, body))
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// 重定向不跟随，按失败处理
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// markResult 记录一次投递结果，失败时按指数退避安排重试
+func markResult(task *delivery, deliverErr error) {
+	attempts := task.attempts + 1
+	var updateReq *pb_gtw.SqlRequest
+	if deliverErr == nil {
+		updateReq = &pb_gtw.SqlRequest{
+			Sql: "UPDATE `group_webhook_delivery` SET `status` = 'delivered', `attempts` = ?, `delivered_time` = UNIX_TIMESTAMP(), `last_error` = '' WHERE `id` = ?",
+			Params: []*pb_gtw.InterFaceType{
+				{Response: &pb_gtw.InterFaceType_Int32{Int32: attempts}},
+				{Response: &pb_gtw.InterFaceType_Int64{Int64: task.id}},
+			},
+		}
+	} else {
+		status := "pending"
+		if maxAttempts := config.LatestConfig.Webhook.MaxAttempts; maxAttempts > 0 && int(attempts) >= maxAttempts {
+			status = "failed"
+		}
+		lastError := deliverErr.Error()
+		if utf8.RuneCountInString(lastError) > maxErrorLength {
+			lastError = string([]rune(lastError)[:maxErrorLength])
+		}
+		updateReq = &pb_gtw.SqlRequest{
+			Sql: "UPDATE `group_webhook_delivery` SET `status` = ?, `attempts` = ?, `next_attempt_time` = UNIX_TIMESTAMP() + ?, `last_error` = ? WHERE `id` = ?",
+			Params: []*pb_gtw.InterFaceType{
+				{Response: &pb_gtw.InterFaceType_Str{Str: status}},
+				{Response: &pb_gtw.InterFaceType_Int32{Int32: attempts}},
+				{Response: &pb_gtw.InterFaceType_Int64{Int64: outbox.Backoff(attempts)}},
+				{Response: &pb_gtw.InterFaceType_Str{Str: lastError}},
+				{Response: &pb_gtw.InterFaceType_Int64{Int64: task.id}},
+			},
+		}
+	}
+	updateReq.Db = pb_gtw.SqlDatabases_Groups
+	updateReq.Commit = true
+	updateResp, err := gateway.ExecSQL(updateReq)
+	if err != nil {
+		log.Printf("[WEBHOOK]Update delivery %d error: %v\n", task.id, err)
+	} else if updateResp.Result.Code != errorcode.Success {
+		log.Printf("[WEBHOOK]Update delivery %d error: [%d]%s\n", task.id, updateResp.Result.Code, updateResp.Result.Msg)
+	}
+}
+
+// dispatch 投递一批到期的回调任务
+func dispatch(client *http.Client) {
+	batchSize := int32(config.LatestConfig.Webhook.BatchSize)
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	pending, err := queryPending(batchSize)
+	if err != nil {
+		log.Printf("[WEBHOOK]Query error: %v\n", err)
+		return
+	}
+	for _, task := range pending {
+		if !claim(task) {
+			continue
+		}
+		markResult(task, post(client, task))
+	}
+}
+
+// Start 启动回调投递循环，投递任务由发件箱通过 Sink 写入
+func Start() {
+	if !config.LatestConfig.Webhook.Enable {
+		return
+	}
+	if !config.LatestConfig.Outbox.Enable {
+		log.Printf("[WEBHOOK]Outbox disabled, webhooks will not be delivered\n")
+		return
+	}
+	timeout := config.LatestConfig.Webhook.Timeout
+	if timeout <= 0 {
+		timeout = 5000
+	}
+	client := newClient(time.Duration(timeout) * time.Millisecond)
+	log.Printf("[WEBHOOK]Start dispatcher\n")
+	for {
+		interval := config.LatestConfig.Webhook.Interval
+		if interval <= 0 {
+			interval = 1000
+		}
+		time.Sleep(time.Duration(interval) * time.Millisecond)
+		dispatch(client)
+	}
+}
@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"StealthIMGroupUser/config"
+)
+
+// errAddressNotAllowed 回调地址指向内网或本机
+var errAddressNotAllowed = errors.New("webhook address not allowed")
+
+// reservedPrefixes 除 netip 判断之外仍不允许投递的保留地址段
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// allowedByConfig 判断地址是否在配置的放行地址段内，配置项可为 CIDR 或单个地址
+func allowedByConfig(addr netip.Addr) bool {
+	for _, element := range config.LatestConfig.Webhook.AllowNetworks {
+		if prefix, err := netip.ParsePrefix(element); err == nil && prefix.Contains(addr) {
+			return true
+		}
+		if single, err := netip.ParseAddr(element); err == nil && single.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedAddr 判断回调能否投递到该地址，本机、内网、链路本地与保留地址默认拒绝
+func AllowedAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if allowedByConfig(addr) {
+		return true
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDialAddress 在建立连接前检查解析后的地址，避免域名解析到内网地址
+func checkDialAddress(network string, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !AllowedAddr(addrPort.Addr()) {
+		return errAddressNotAllowed
+	}
+	return nil
+}
+
+// newClient 创建回调使用的 HTTP 客户端，不经过代理且不跟随重定向
+func newClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: checkDialAddress}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
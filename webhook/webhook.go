@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	pb_gtw "StealthIMGroupUser/StealthIM.DBGateway"
+	pb "StealthIMGroupUser/StealthIM.GroupUser"
+	"StealthIMGroupUser/errorcode"
+	"StealthIMGroupUser/gateway"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Sink 将发件箱事件展开为各个订阅回调的投递任务
+// 投递任务按 (webhook_id, event_seq) 去重，发件箱重复投递不会产生重复回调
+type Sink struct{}
+
+// Name 投递目标名称
+func (s *Sink) Name() string {
+	return "webhook"
+}
+
+// Deliver 为订阅了该事件的群组回调写入投递任务
+func (s *Sink) Deliver(ev *pb.GroupEvent) error {
+	payload, err := protojson.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	insertReq := &pb_gtw.SqlRequest{
+		Sql: "INSERT IGNORE INTO `group_webhook_delivery` (`webhook_id`, `event_seq`, `event_type`, `payload`, `next_attempt_time`) " +
+			"SELECT `id`, ?, ?, ?, UNIX_TIMESTAMP() FROM `group_webhook` WHERE `groupid` = ? AND (`events` = '' OR FIND_IN_SET(?, `events`) > 0)",
+		Db:     pb_gtw.SqlDatabases_Groups,
+		Commit: true,
+		Params: []*pb_gtw.InterFaceType{
+			{Response: &pb_gtw.InterFaceType_Int64{Int64: ev.Seq}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: ev.Type.String()}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: string(payload)}},
+			{Response: &pb_gtw.InterFaceType_Int32{Int32: ev.GroupId}},
+			{Response: &pb_gtw.InterFaceType_Str{Str: ev.Type.String()}},
+		},
+	}
+	insertResp, err := gateway.ExecSQL(insertReq)
+	if err != nil {
+		return err
+	}
+	if insertResp.Result.Code != errorcode.Success {
+		return fmt.Errorf("[%d]%s", insertResp.Result.Code, insertResp.Result.Msg)
+	}
+	return nil
+}
+
+// Sign 计算回调签名，签名内容为 "时间戳.请求体"，接收方应使用相同密钥校验
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}